@@ -0,0 +1,411 @@
+package log
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	archiveSuffix        = ".archive"
+	defArchiveMinBackoff = time.Second
+	defArchiveMaxBackoff = time.Minute * 5
+)
+
+// ArchiveSink 备份文件的归档目的地
+type ArchiveSink interface {
+	// Archive 归档一个备份文件，name 为文件名，r 为文件内容，size 为文件大小，
+	// 返回 nil 表示已确认归档成功
+	Archive(ctx context.Context, name string, r io.Reader, size int64) error
+}
+
+// ArchiveOptions 归档选项
+type ArchiveOptions struct {
+	// DeleteAfterUpload 归档成功后删除本地备份文件
+	DeleteAfterUpload bool
+	// MinBackoff 归档失败后首次重试的间隔，之后每次加倍，默认 1 秒
+	MinBackoff time.Duration
+	// MaxBackoff 重试间隔的上限，默认 5 分钟
+	MaxBackoff time.Duration
+}
+
+// archiver 在后台依次归档备份文件，待归档队列保存在日志目录中，进程重启后继续归档
+type archiver struct {
+	sink    ArchiveSink
+	opts    ArchiveOptions
+	onError func(error)
+
+	mutex     sync.Mutex
+	fs        FileSystem
+	queuePath string
+	pending   []string
+	inflight  string
+	started   bool
+	running   bool
+	closed    bool
+	wakeup    chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// SetArchive 设置备份文件归档，切分出的备份文件(开启压缩时为压缩后的文件)依次交给 sink 归档，
+// 失败时按指数退避重试。尚未归档的备份文件不会被保留策略清理
+func (fw *FileWriter) SetArchive(sink ArchiveSink, opts ArchiveOptions) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.archiver != nil {
+		fw.archiver.close()
+		fw.archiver = nil
+	}
+	if sink == nil {
+		return
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defArchiveMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = defArchiveMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	fw.archiver = &archiver{
+		sink:    sink,
+		opts:    opts,
+		onError: fw.reportError,
+		wakeup:  make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	// 日志文件已打开时不会再经过 newFile 的恢复流程，在这里启动归档
+	if fw.recovered {
+		if err := fw.recoverArchive(); err != nil {
+			fw.reportError(err)
+		}
+		fw.archiver.launch()
+	}
+}
+
+// archiveQueuePath 返回待归档队列文件的路径
+func (fw *FileWriter) archiveQueuePath() string {
+	return filepath.Join(fw.dir, "."+fw.name+archiveSuffix)
+}
+
+// recoverArchive 读取上次进程退出时未归档的队列，归档协程在处理完未完成的压缩后由 launch 启动
+func (fw *FileWriter) recoverArchive() error {
+	if fw.archiver == nil {
+		return nil
+	}
+	return fw.archiver.start(fw.filesystem(), fw.archiveQueuePath())
+}
+
+// start 读取持久化的归档队列，之后可以接受新的归档文件。
+// 已经启动时若队列位置变化(日志目录或文件名变化)，将队列合并到新位置并删除旧的队列文件
+func (a *archiver) start(fs FileSystem, queuePath string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closed || a.started && a.queuePath == queuePath {
+		return nil
+	}
+	paths, err := readQueue(fs, queuePath)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if !a.contains(path) {
+			a.pending = append(a.pending, path)
+		}
+	}
+	oldFS, oldPath := a.fs, a.queuePath
+	moved := a.started
+	a.started = true
+	a.fs = fs
+	a.queuePath = queuePath
+	if moved {
+		if err := a.persist(); err != nil {
+			return err
+		}
+		if err := oldFS.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	a.signal()
+	return nil
+}
+
+// readQueue 读取归档队列文件，文件不存在时返回空队列
+func readQueue(fs FileSystem, queuePath string) ([]string, error) {
+	data, err := readFile(fs, queuePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// launch 启动归档协程，可重复调用
+func (a *archiver) launch() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.started || a.running || a.closed {
+		return
+	}
+	a.running = true
+	go a.run()
+}
+
+// contains 判断文件是否在队列中，调用方需持有 mutex
+func (a *archiver) contains(path string) bool {
+	for _, p := range a.pending {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// queued 判断文件是否等待归档或正在归档
+func (a *archiver) queued(path string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.contains(path)
+}
+
+// enqueue 将备份文件加入归档队列并持久化队列
+func (a *archiver) enqueue(path string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.started || a.closed || a.contains(path) {
+		return
+	}
+	a.pending = append(a.pending, path)
+	if err := a.persist(); err != nil {
+		a.onError(err)
+	}
+	a.signal()
+}
+
+// compressed 备份文件压缩完成后调用，队列中尚未开始归档的源文件替换为压缩文件，
+// 否则将压缩文件加入队列
+func (a *archiver) compressed(src, dst string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.started || a.closed {
+		return
+	}
+	if a.contains(dst) {
+		return
+	}
+	replaced := false
+	for i, path := range a.pending {
+		if path == src && path != a.inflight {
+			a.pending[i] = dst
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		a.pending = append(a.pending, dst)
+	}
+	if err := a.persist(); err != nil {
+		a.onError(err)
+	}
+	a.signal()
+}
+
+// remove 将尚未开始归档的文件移出队列
+func (a *archiver) remove(path string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.started || a.closed {
+		return
+	}
+	for i, p := range a.pending {
+		if p == path && p != a.inflight {
+			a.pending = append(a.pending[:i], a.pending[i+1:]...)
+			if err := a.persist(); err != nil {
+				a.onError(err)
+			}
+			return
+		}
+	}
+}
+
+func (a *archiver) signal() {
+	select {
+	case a.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// persist 先写临时文件再重命名，保存归档队列，调用方需持有 mutex
+func (a *archiver) persist() error {
+	if len(a.pending) == 0 {
+		err := a.fs.Remove(a.queuePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	tmp := a.queuePath + ".tmp"
+	if err := writeFile(a.fs, tmp, []byte(strings.Join(a.pending, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return a.fs.Rename(tmp, a.queuePath)
+}
+
+// pendingFiles 返回待归档的文件
+func (a *archiver) pendingFiles() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]string(nil), a.pending...)
+}
+
+// close 中断正在进行的归档并停止后台协程，未完成的归档在下次启动时继续
+func (a *archiver) close() {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return
+	}
+	a.closed = true
+	running := a.running
+	a.mutex.Unlock()
+	a.cancel()
+	if running {
+		<-a.done
+	}
+}
+
+func (a *archiver) run() {
+	defer close(a.done)
+	backoff := a.opts.MinBackoff
+	for {
+		a.mutex.Lock()
+		var path string
+		if len(a.pending) > 0 {
+			path = a.pending[0]
+		}
+		a.inflight = path
+		a.mutex.Unlock()
+
+		if path == "" {
+			select {
+			case <-a.wakeup:
+				continue
+			case <-a.ctx.Done():
+				return
+			}
+		}
+
+		err := a.upload(path)
+		if a.ctx.Err() != nil {
+			return
+		}
+		if err != nil && !os.IsNotExist(err) {
+			a.onError(err)
+			select {
+			case <-time.After(backoff):
+			case <-a.ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > a.opts.MaxBackoff {
+				backoff = a.opts.MaxBackoff
+			}
+			continue
+		}
+		// 文件已不存在(例如被保留策略清理)时同样移出队列
+		if err != nil {
+			a.onError(err)
+		}
+		backoff = a.opts.MinBackoff
+		a.mutex.Lock()
+		a.inflight = ""
+		if len(a.pending) > 0 && a.pending[0] == path {
+			a.pending = a.pending[1:]
+		}
+		if perr := a.persist(); perr != nil {
+			a.onError(perr)
+		}
+		a.mutex.Unlock()
+	}
+}
+
+// upload 归档一个文件，成功后按需删除本地文件
+func (a *archiver) upload(path string) error {
+	f, err := a.fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = a.sink.Archive(a.ctx, filepath.Base(path), f, info.Size())
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if a.opts.DeleteAfterUpload {
+		if err := a.fs.Remove(path); err != nil && !os.IsNotExist(err) {
+			a.onError(err)
+		}
+	}
+	return nil
+}
+
+// DirSink 将备份文件复制到本地归档目录
+type DirSink struct {
+	// Dir 归档目录，不存在时自动创建
+	Dir string
+	// FS 归档目录所在的文件系统，为 nil 时使用 OSFileSystem
+	FS FileSystem
+}
+
+// Archive 实现 ArchiveSink 接口
+func (s *DirSink) Archive(ctx context.Context, name string, r io.Reader, size int64) error {
+	fs := s.FS
+	if fs == nil {
+		fs = OSFileSystem{}
+	}
+	if err := fs.MkdirAll(s.Dir, 0777); err != nil {
+		return err
+	}
+	dst := filepath.Join(s.Dir, name)
+	tmp := dst + ".tmp"
+	f, err := fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return fs.Rename(tmp, dst)
+}
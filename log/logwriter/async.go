@@ -0,0 +1,243 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// OverflowPolicy 异步写入队列已满时的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞等待队列有空间
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest 丢弃本次写入的数据
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃队列中最早的数据
+	OverflowDropOldest
+)
+
+// asyncQueue 异步写入队列，容量按字节计算，由单个协程批量写入日志文件
+type asyncQueue struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	records  [][]byte
+	bytes    int
+	capacity int
+	overflow OverflowPolicy
+	inflight bool
+	puts     int64
+	dropped  int64
+	err      error
+	closed   bool
+	done     chan struct{}
+}
+
+func newAsyncQueue(capacity int, overflow OverflowPolicy) *asyncQueue {
+	q := &asyncQueue{
+		capacity: capacity,
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// SetAsync 开启异步写入，Write 只将数据放入容量为 queueSize 字节的队列，
+// 由后台协程批量写入日志文件，队列已满时按 overflow 处理。
+// 需要在首次写入前调用，开启后不能关闭
+func (fw *FileWriter) SetAsync(queueSize int, overflow OverflowPolicy) {
+	if fw.async != nil {
+		return
+	}
+	q := newAsyncQueue(queueSize, overflow)
+	fw.async = q
+	go fw.asyncLoop(q)
+}
+
+// Flush 等待异步写入队列中的数据全部写入日志文件，再将日志文件刷盘，
+// 返回上次 Flush 之后后台写入的第一个错误(按 SetFallback 降级成功的不算)或刷盘的错误
+func (fw *FileWriter) Flush() error {
+	var firstErr error
+	if fw.async != nil {
+		firstErr = fw.async.wait()
+	}
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if !fw.closed {
+		if err := fw.syncFile(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// wait 等待队列中的数据全部被取出并处理完，返回并清除期间记录的第一个写入错误
+func (q *asyncQueue) wait() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.records) > 0 || q.inflight {
+		q.cond.Wait()
+	}
+	err := q.err
+	q.err = nil
+	return err
+}
+
+// fail 记录后台写入的错误，只保留第一个，由 wait 返回
+func (q *asyncQueue) fail(err error) {
+	q.mutex.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mutex.Unlock()
+}
+
+// take 取出队列中的全部数据，队列为空时等待，队列已关闭且为空时返回 false。
+// 取出的数据处理完后需调用 release
+func (q *asyncQueue) take() ([][]byte, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.records) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.records) == 0 {
+		return nil, false
+	}
+	batch := q.records
+	q.records = nil
+	q.bytes = 0
+	q.inflight = true
+	q.cond.Broadcast()
+	return batch, true
+}
+
+// release 标记 take 取出的数据已处理完
+func (q *asyncQueue) release() {
+	q.mutex.Lock()
+	q.inflight = false
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+}
+
+// put 将 p 的副本放入队列
+func (q *asyncQueue) put(p []byte) (int, error) {
+	return q.putTimeout(p, 0)
+}
+
+// putTimeout 同 put，OverflowBlock 时最多等待 timeout，超时丢弃 p 并计入丢弃字节数，
+// timeout 为 0 时一直等待
+func (q *asyncQueue) putTimeout(p []byte, timeout time.Duration) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return 0, ErrClosed
+	}
+	var deadline time.Time
+	// 队列为空时总是接受，避免超过容量的单次写入永远无法写入
+	for q.bytes > 0 && q.bytes+len(p) > q.capacity {
+		switch q.overflow {
+		case OverflowDropNewest:
+			q.dropped += int64(len(p))
+			return len(p), nil
+		case OverflowDropOldest:
+			q.dropped += int64(len(q.records[0]))
+			q.bytes -= len(q.records[0])
+			q.records[0] = nil
+			q.records = q.records[1:]
+		default:
+			if timeout > 0 {
+				if deadline.IsZero() {
+					deadline = time.Now().Add(timeout)
+					timer := time.AfterFunc(timeout, func() {
+						q.mutex.Lock()
+						q.cond.Broadcast()
+						q.mutex.Unlock()
+					})
+					defer timer.Stop()
+				} else if !time.Now().Before(deadline) {
+					q.dropped += int64(len(p))
+					return len(p), nil
+				}
+			}
+			q.cond.Wait()
+			if q.closed {
+				return 0, ErrClosed
+			}
+		}
+	}
+	record := make([]byte, len(p))
+	copy(record, p)
+	q.records = append(q.records, record)
+	q.bytes += len(record)
+	q.puts++
+	q.cond.Broadcast()
+	return len(p), nil
+}
+
+// close 写完队列中剩余的数据后停止后台协程，可重复调用
+func (q *asyncQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+	<-q.done
+}
+
+// putCount 返回放入队列的次数
+func (q *asyncQueue) putCount() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.puts
+}
+
+func (q *asyncQueue) droppedBytes() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.dropped
+}
+
+// asyncLoop 每次取出队列中的全部数据，合并后一次写入日志文件
+func (fw *FileWriter) asyncLoop(q *asyncQueue) {
+	defer close(q.done)
+	var buf []byte
+	for {
+		batch, ok := q.take()
+		if !ok {
+			return
+		}
+		fw.fileMutex.Lock()
+		if !fw.closed {
+			if fw.records != nil || fw.chain != nil {
+				// 按记录写入或防篡改模式下队列中的每一项都是一条完整的记录
+				for _, record := range batch {
+					if n, err := fw.writeRecord(record); err != nil {
+						if _, err := fw.writeFailed(record, n, err); err != nil {
+							q.fail(err)
+						}
+					}
+				}
+			} else {
+				buf = buf[:0]
+				for _, record := range batch {
+					if ok, err := fw.admit(record); ok {
+						buf = append(buf, record...)
+					} else if err != nil {
+						if _, err := fw.writeFailed(record, 0, err); err != nil {
+							q.fail(err)
+						}
+					}
+				}
+				if len(buf) > 0 {
+					if n, err := fw.write(buf); err != nil {
+						if _, err := fw.writeFailed(buf, n, err); err != nil {
+							q.fail(err)
+						}
+					}
+				}
+			}
+		}
+		fw.fileMutex.Unlock()
+		q.release()
+	}
+}
@@ -0,0 +1,91 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAsyncWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewSizeSplitWriter(1000)
+	writer.SetDir(dir)
+	writer.SetAsync(64, OverflowBlock)
+
+	var want bytes.Buffer
+	for i := 0; i < 100; i++ {
+		line := []byte("hello world\n")
+		if n, err := writer.Write(line); err != nil || n != len(line) {
+			t.Fatalf("Write: got (%d, %v)", n, err)
+		}
+		want.Write(line)
+	}
+	writer.Flush()
+
+	var got []byte
+	backups, _ := writer.listBackups()
+	for _, b := range backups {
+		data, _ := ioutil.ReadFile(b.path)
+		got = append(got, data...)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, defName))
+	got = append(got, data...)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("got %d bytes, want %d", len(got), want.Len())
+	}
+	if writer.Dropped() != 0 {
+		t.Errorf("Dropped: got %d", writer.Dropped())
+	}
+
+	writer.Close()
+	if _, err := writer.Write([]byte("x")); err != ErrClosed {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
+
+func TestAsyncFlushError(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(100)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	writer.SetAsync(64, OverflowBlock)
+	writer.SetErrorHandler(func(error) {})
+	defer writer.Close()
+
+	// 后台写入失败时由下一次 Flush 返回，之后的 Flush 不再返回
+	fs.SetCapacity(4)
+	mustWrite(t, writer, "hello\n")
+	if err := writer.Flush(); err == nil {
+		t.Error("Flush: got nil error after failed write")
+	}
+	if err := writer.Flush(); err != nil {
+		t.Errorf("second Flush: got %v", err)
+	}
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	for _, overflow := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		q := newAsyncQueue(10, overflow)
+		for _, s := range []string{"aaaa", "bbbb", "cccc"} {
+			if n, err := q.put([]byte(s)); err != nil || n != 4 {
+				t.Fatalf("put: got (%d, %v)", n, err)
+			}
+		}
+		if q.dropped != 4 || q.bytes != 8 {
+			t.Errorf("overflow %d: dropped %d, queued %d", overflow, q.dropped, q.bytes)
+		}
+		want := "aaaa"
+		if overflow == OverflowDropOldest {
+			want = "bbbb"
+		}
+		if string(q.records[0]) != want {
+			t.Errorf("overflow %d: head %q, want %q", overflow, q.records[0], want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// IntegrityMode 防篡改模式下链式哈希的计算方式
+type IntegrityMode int
+
+const (
+	// IntegrityNone 不计算链式哈希
+	IntegrityNone IntegrityMode = iota
+	// IntegritySHA256 使用 SHA-256 计算链式哈希，可以发现篡改但无法防止重新计算整条链
+	IntegritySHA256
+	// IntegrityHMAC 使用配置的密钥计算 HMAC-SHA256，没有密钥无法伪造
+	IntegrityHMAC
+)
+
+// 防篡改模式下日志文件中的标记行
+//
+//	#chain start <上一个文件的最终哈希>
+//	#chain <记录长度> <写入该记录后的哈希>
+//	<记录内容>
+//	...
+//	#chain end <最终哈希> [<文件尾的哈希>]
+//	<文件尾>
+//
+// 每条记录的哈希为 H(上一条记录的哈希 || 记录内容)，第一个文件的起始哈希为全零。
+// 文件头在 start 之前，不参与链式哈希；文件尾在 end 之后，其哈希为 H(最终哈希 || 文件尾)，
+// 没有文件尾时 end 之后不能有任何内容
+const (
+	chainStart  = "#chain start "
+	chainRecord = "#chain "
+	chainEnd    = "#chain end "
+)
+
+// ErrChainKey 使用 IntegrityHMAC 但没有提供密钥时返回
+var ErrChainKey = errors.New("logwriter: hmac integrity requires a key")
+
+// hashChain 链式哈希的状态
+type hashChain struct {
+	mode  IntegrityMode
+	key   []byte
+	last  []byte
+	ready bool
+}
+
+func newHashChain(mode IntegrityMode, key []byte) (*hashChain, error) {
+	if mode == IntegrityHMAC && len(key) == 0 {
+		return nil, ErrChainKey
+	}
+	return &hashChain{mode: mode, key: append([]byte(nil), key...)}, nil
+}
+
+// hasher 返回已写入 prev 的哈希，再写入记录内容即可得到下一条记录的哈希
+func (c *hashChain) hasher(prev []byte) hash.Hash {
+	var h hash.Hash
+	if c.mode == IntegrityHMAC {
+		h = hmac.New(sha256.New, c.key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(prev)
+	return h
+}
+
+// next 返回在 prev 之后写入 record 的哈希
+func (c *hashChain) next(prev, record []byte) []byte {
+	h := c.hasher(prev)
+	h.Write(record)
+	return h.Sum(nil)
+}
+
+// frame 返回带标记行的记录和写入后的哈希，记录不以换行结尾时补上换行
+func (c *hashChain) frame(record []byte) ([]byte, []byte) {
+	if len(record) == 0 || record[len(record)-1] != '\n' {
+		record = append(record[:len(record):len(record)], '\n')
+	}
+	sum := c.next(c.last, record)
+	data := make([]byte, 0, len(chainRecord)+len(record)+90)
+	data = append(data, chainRecord...)
+	data = strconv.AppendInt(data, int64(len(record)), 10)
+	data = append(data, ' ')
+	data = append(data, hex.EncodeToString(sum)...)
+	data = append(data, '\n')
+	data = append(data, record...)
+	return data, sum
+}
+
+// SetIntegrity 开启防篡改模式，每条记录(未开启按记录写入时为每次 Write 的数据)之前写入
+// 链式哈希标记行，每个日志文件记录上一个文件的最终哈希，可以用 Verify 校验。
+// mode 为 IntegrityHMAC 时 key 不能为空，已开启多进程共享时返回 ErrSharedConflict。需要在首次写入前调用
+func (fw *FileWriter) SetIntegrity(mode IntegrityMode, key []byte) error {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if mode == IntegrityNone {
+		fw.chain = nil
+		return nil
+	}
+	if fw.shared {
+		return ErrSharedConflict
+	}
+	c, err := newHashChain(mode, key)
+	if err != nil {
+		return err
+	}
+	fw.chain = c
+	return nil
+}
+
+// writeChainStart 在新日志文件中写入起始标记，起始哈希为上一个文件的最终哈希，调用方需持有 fileMutex
+func (fw *FileWriter) writeChainStart() error {
+	if !fw.chain.ready {
+		fw.chain.last = fw.lastBackupHash()
+		fw.chain.ready = true
+	}
+	line := []byte(chainStart + hex.EncodeToString(fw.chain.last) + "\n")
+	n, err := fw.file.Write(line)
+	fw.size += int64(n)
+	fw.track(line[:n], 0)
+	return err
+}
+
+// writeChainEnd 在备份文件中写入结束标记，footer 为随后写入的文件尾，调用方需持有 fileMutex
+func (fw *FileWriter) writeChainEnd(footer []byte) error {
+	line := chainEnd + hex.EncodeToString(fw.chain.last)
+	if len(footer) > 0 {
+		line += " " + hex.EncodeToString(fw.chain.next(fw.chain.last, footer))
+	}
+	line += "\n"
+	n, err := io.WriteString(fw.file, line)
+	fw.size += int64(n)
+	fw.track([]byte(line[:n]), 0)
+	return err
+}
+
+// resumeChain 以追加方式重新打开已有文件时，从文件中恢复最近一条记录的哈希和记录数，调用方需持有 fileMutex
+func (fw *FileWriter) resumeChain() error {
+	f, err := fw.filesystem().OpenFile(fw.fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := fw.chain.scan(f, fw.fileName)
+	fw.chain.last = st.last
+	fw.chain.ready = true
+	if fw.digest != nil {
+		// 文件尾按链中的记录数统计，不按行数
+		fw.fileRecords = st.records
+	}
+	return err
+}
+
+// lastBackupHash 返回最近一个备份文件的最终哈希，没有备份或无法读取时返回全零
+func (fw *FileWriter) lastBackupHash() []byte {
+	zero := make([]byte, sha256.Size)
+	backups, err := fw.listBackups()
+	if err != nil || len(backups) == 0 {
+		return zero
+	}
+	b := backups[len(backups)-1]
+	r, err := openBackup(fw.filesystem(), fw.compressorOf(), b)
+	if err != nil {
+		fw.reportError(err)
+		return zero
+	}
+	defer r.Close()
+	st, err := fw.chain.scan(r, b.path)
+	if err != nil {
+		fw.reportError(err)
+	}
+	if st.end == nil {
+		return zero
+	}
+	return st.end
+}
+
+// ChainBreak 校验发现的第一处断链
+type ChainBreak struct {
+	// File 出错的文件
+	File string
+	// Record 出错的记录序号，从 1 开始，为 0 时表示文件本身的标记有误
+	Record int64
+	// Reason 出错原因
+	Reason string
+}
+
+func (e *ChainBreak) Error() string {
+	if e.Record == 0 {
+		return fmt.Sprintf("logwriter: hash chain broken in %s: %s", e.File, e.Reason)
+	}
+	return fmt.Sprintf("logwriter: hash chain broken in %s at record %d: %s", e.File, e.Record, e.Reason)
+}
+
+// chainState 扫描一个日志文件得到的链式哈希
+type chainState struct {
+	start   []byte
+	last    []byte
+	end     []byte
+	records int64
+}
+
+// scan 校验一个日志文件中的链式哈希，返回 *ChainBreak 或读取错误
+func (c *hashChain) scan(r io.Reader, name string) (chainState, error) {
+	var st chainState
+	br := bufio.NewReader(r)
+	broken := func(reason string) (chainState, error) {
+		return st, &ChainBreak{File: name, Record: st.records, Reason: reason}
+	}
+	empty := true
+	for st.start == nil {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			empty = false
+		}
+		if strings.HasPrefix(line, chainStart) {
+			sum, herr := hex.DecodeString(strings.TrimSpace(strings.TrimPrefix(line, chainStart)))
+			if herr != nil || len(sum) != sha256.Size {
+				return broken("malformed start marker")
+			}
+			st.start, st.last = sum, sum
+			break
+		}
+		if err == io.EOF {
+			if empty {
+				return st, nil
+			}
+			return broken("missing start marker")
+		}
+		if err != nil {
+			return st, err
+		}
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return st, nil
+		}
+		if err != nil && err != io.EOF {
+			return st, err
+		}
+		st.records++
+		if strings.HasPrefix(line, chainEnd) {
+			st.records--
+			fields := strings.Fields(strings.TrimPrefix(line, chainEnd))
+			if len(fields) == 0 || len(fields) > 2 || fields[0] != hex.EncodeToString(st.last) {
+				return broken("end marker does not match the last record")
+			}
+			h := c.hasher(st.last)
+			n, err := io.Copy(h, br)
+			if err != nil {
+				return st, err
+			}
+			if len(fields) == 1 && n > 0 {
+				return broken("unexpected data after the end marker")
+			}
+			if len(fields) == 2 && hex.EncodeToString(h.Sum(nil)) != fields[1] {
+				return broken("footer hash mismatch")
+			}
+			st.end = st.last
+			return st, nil
+		}
+		if !strings.HasPrefix(line, chainRecord) || !strings.HasSuffix(line, "\n") {
+			return broken("unexpected data outside a record")
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, chainRecord))
+		if len(fields) != 2 {
+			return broken("malformed record marker")
+		}
+		size, perr := strconv.ParseInt(fields[0], 10, 64)
+		if perr != nil || size < 0 {
+			return broken("malformed record marker")
+		}
+		// 记录长度来自文件内容，不能据此分配内存，边读边计算哈希
+		h := c.hasher(st.last)
+		if _, err := io.CopyN(h, br, size); err != nil {
+			if err == io.EOF {
+				return broken("truncated record")
+			}
+			return st, err
+		}
+		sum := h.Sum(nil)
+		if hex.EncodeToString(sum) != fields[1] {
+			return broken("record hash mismatch")
+		}
+		st.last = sum
+	}
+}
+
+// Verify 按从旧到新的顺序校验日志目录中的备份文件和当前日志文件，
+// 检查每条记录的哈希、每个文件的结束标记，以及每个文件的起始哈希是否等于上一个文件的最终哈希。
+// 发现断链时返回 *ChainBreak，最早的备份文件可能已被清理，不校验它的起始哈希
+func (fw *FileWriter) Verify() error {
+	fw.fileMutex.Lock()
+	chain := fw.chain
+	fs := fw.filesystem()
+	c := fw.compressorOf()
+	fileName := fw.fileName
+	if fileName == "" {
+		fileName = filepath.Join(fw.dir, fw.name)
+	}
+	backups, err := fw.listBackups()
+	var size int64 = -1
+	if info, serr := fs.Stat(fileName); serr == nil {
+		size = info.Size()
+	}
+	fw.fileMutex.Unlock()
+	if chain == nil {
+		return errors.New("logwriter: integrity mode not enabled")
+	}
+	if err != nil {
+		return err
+	}
+	v := &hashChain{mode: chain.mode, key: chain.key}
+
+	var prev []byte
+	check := func(r io.Reader, name string, backup bool) error {
+		st, err := v.scan(r, name)
+		if err != nil {
+			return err
+		}
+		if st.start == nil {
+			return nil
+		}
+		if prev != nil && !bytes.Equal(st.start, prev) {
+			return &ChainBreak{File: name, Reason: "start hash does not match the previous file"}
+		}
+		if backup && st.end == nil {
+			return &ChainBreak{File: name, Record: st.records, Reason: "missing end marker"}
+		}
+		prev = st.end
+		return nil
+	}
+	for _, b := range backups {
+		r, err := openBackup(fs, c, b)
+		if err != nil {
+			return err
+		}
+		err = check(r, b.path, true)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if size < 0 {
+		return nil
+	}
+	f, err := fs.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// 只校验开始校验时已写入的内容
+	return check(io.LimitReader(f, size), fileName, false)
+}
+
+// Verify 校验 dir 中使用默认文件名 default.log 和默认备份命名模板的日志文件，key 为空时按 IntegritySHA256 校验，
+// 否则按 IntegrityHMAC 校验。其他文件名或命名模板的日志需要设置好 FileWriter 后调用 FileWriter.Verify
+func Verify(dir string, key []byte) error {
+	fw, _ := NewDateSplitWriter()
+	fw.SetDir(dir)
+	mode := IntegritySHA256
+	if len(key) > 0 {
+		mode = IntegrityHMAC
+	}
+	if err := fw.SetIntegrity(mode, key); err != nil {
+		return err
+	}
+	return fw.Verify()
+}
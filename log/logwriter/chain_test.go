@@ -0,0 +1,177 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newChainWriter(t *testing.T, fs *MemFS, clock *manualClock) *FileWriter {
+	writer, _ := NewSizeSplitWriter(300)
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	writer.SetHeader(DefaultHeader)
+	if err := writer.SetIntegrity(IntegrityHMAC, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	return writer
+}
+
+func TestIntegrityChain(t *testing.T) {
+	clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs := NewMemFS(clock)
+	writer := newChainWriter(t, fs, clock)
+	for i := 0; i < 6; i++ {
+		mustWrite(t, writer, "tx committed\n")
+	}
+	writer.Close()
+
+	// 重新打开后继续当前文件的链
+	writer = newChainWriter(t, fs, clock)
+	for i := 0; i < 6; i++ {
+		mustWrite(t, writer, "block appended")
+	}
+	if err := writer.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	backups, _ := writer.listBackups()
+	if len(backups) < 2 {
+		t.Fatalf("got %d backups, want at least 2", len(backups))
+	}
+	if err := writer.SetIntegrity(IntegritySHA256, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := writer.Verify().(*ChainBreak); !ok {
+		t.Error("Verify with the wrong mode succeeded")
+	}
+	writer.SetIntegrity(IntegrityHMAC, []byte("secret"))
+
+	// 修改一条记录
+	first := backups[0].path
+	data, _ := fs.ReadFile(first)
+	tampered := bytes.Replace(data, []byte("tx committed"), []byte("tx rejected!"), 1)
+	fs.WriteFile(first, tampered, 0644)
+	err := writer.Verify()
+	if b, ok := err.(*ChainBreak); !ok || b.File != first || b.Record != 1 {
+		t.Errorf("tampered record: got %v", err)
+	}
+	fs.WriteFile(first, data, 0644)
+
+	// 删除中间的备份文件
+	fs.Remove(backups[1].path)
+	err = writer.Verify()
+	if b, ok := err.(*ChainBreak); !ok || b.Record != 0 {
+		t.Errorf("removed backup: got %v", err)
+	}
+}
+
+func TestVerifyDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewSizeSplitWriter(200)
+	writer.SetDir(dir)
+	writer.SetCompressor(GzipCompressor)
+	writer.SetIntegrity(IntegritySHA256, nil)
+	for i := 0; i < 10; i++ {
+		mustWrite(t, writer, "peer joined channel\n")
+	}
+	writer.Close()
+
+	if backups, _ := writer.listBackups(); len(backups) == 0 || backups[0].ext != ".gz" {
+		t.Errorf("got backups %+v, want compressed backups", backups)
+	}
+	if err := Verify(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(dir, []byte("secret")); err == nil {
+		t.Error("Verify with a key succeeded on a SHA-256 chain")
+	}
+}
+
+func TestChainAfterEndMarker(t *testing.T) {
+	clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs := &failRenameFS{MemFS: NewMemFS(clock), failures: 1}
+	writer, _ := NewDateSplitWriter()
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	writer.SetFooter(DefaultFooter)
+	writer.SetErrorHandler(func(error) {})
+	if err := writer.SetIntegrity(IntegrityHMAC, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	// 第一次切分重命名失败，继续写入原文件，下次写入时切分成功
+	mustWrite(t, writer, "hello\n")
+	clock.Add(24 * time.Hour)
+	mustWrite(t, writer, "world\n")
+	mustWrite(t, writer, "again\n")
+	writer.Close()
+	if err := writer.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ := writer.listBackups()
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	name := backups[0].path
+	data, _ := fs.ReadFile(name)
+	if strings.Count(string(data), chainEnd) != 1 {
+		t.Fatalf("got backup %q, want exactly one end marker", data)
+	}
+	for _, c := range []struct {
+		desc     string
+		tampered string
+	}{
+		{"record", strings.Replace(string(data), "world", "WORLD", 1)},
+		{"footer", strings.Replace(string(data), "records=2", "records=9", 1)},
+		{"appended", string(data) + "forged\n"},
+	} {
+		fs.WriteFile(name, []byte(c.tampered), 0644)
+		if _, ok := writer.Verify().(*ChainBreak); !ok {
+			t.Errorf("%s: tampering after the end marker not detected", c.desc)
+		}
+	}
+
+	// 没有文件尾时结束标记之后不能有任何内容
+	fs.WriteFile(name, data, 0644)
+	writer, _ = NewDateSplitWriter()
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	writer.SetIntegrity(IntegrityHMAC, []byte("secret"))
+	clock.Add(24 * time.Hour)
+	mustWrite(t, writer, "more\n")
+	writer.Close()
+	backups, _ = writer.listBackups()
+	last := backups[len(backups)-1].path
+	data, _ = fs.ReadFile(last)
+	fs.WriteFile(last, append(data, "forged\n"...), 0644)
+	if b, ok := writer.Verify().(*ChainBreak); !ok || b.File != last {
+		t.Errorf("data after the end marker: got %v", writer.Verify())
+	}
+}
+
+func TestChainHugeRecordLength(t *testing.T) {
+	clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs := NewMemFS(clock)
+	writer := newChainWriter(t, fs, clock)
+	mustWrite(t, writer, "tx committed\n")
+	writer.Close()
+
+	// 篡改后的记录长度不会导致按该长度分配内存
+	name := writer.livePath()
+	data, _ := fs.ReadFile(name)
+	tampered := strings.Replace(string(data), "#chain 13 ", "#chain 999999999999 ", 1)
+	fs.WriteFile(name, []byte(tampered), 0644)
+	if b, ok := writer.Verify().(*ChainBreak); !ok || b.Reason != "truncated record" {
+		t.Errorf("got %v, want a truncated record", writer.Verify())
+	}
+}
@@ -0,0 +1,340 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// compressTmpSuffix 压缩过程中临时文件的后缀，压缩完成后重命名为正式文件
+const compressTmpSuffix = ".tmp"
+
+// Compressor 备份文件压缩算法
+//
+// 只内置了 GzipCompressor，其他算法可以按需接入，例如标准库的 zlib：
+//
+//	&Compressor{
+//		Ext: ".zz",
+//		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+//			return zlib.NewWriter(w), nil
+//		},
+//		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+//			return zlib.NewReader(r)
+//		},
+//	}
+type Compressor struct {
+	// Ext 压缩文件后缀，如 ".gz"
+	Ext string
+	// NewWriter 返回写入 w 的压缩 writer
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	// NewReader 返回从 r 读取的解压 reader，用于校验和读取压缩后的备份文件，可以为空
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCompressor gzip 压缩
+var GzipCompressor = &Compressor{
+	Ext: ".gz",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+// compressWorker 在后台依次压缩切分出来的备份文件，不阻塞日志写入
+type compressWorker struct {
+	compressor *Compressor
+	onError    func(error)
+	mutex      sync.Mutex
+	onDone     func(src, dst string, err error)
+	pending    []compressJob
+	current    string
+	closed     bool
+	wakeup     chan struct{}
+	once       sync.Once
+	idle       sync.WaitGroup
+}
+
+// compressJob 待压缩的备份文件
+type compressJob struct {
+	fs   FileSystem
+	path string
+}
+
+func newCompressWorker(c *Compressor) *compressWorker {
+	return &compressWorker{
+		compressor: c,
+		wakeup:     make(chan struct{}, 1),
+	}
+}
+
+// SetCompressor 设置备份文件压缩算法，为 nil 时不压缩，替换后等待已切分的备份按原算法压缩完成
+func (fw *FileWriter) SetCompressor(c *Compressor) {
+	fw.fileMutex.Lock()
+	old := fw.setCompressor(c)
+	fw.fileMutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// setCompressor 替换备份文件压缩算法，返回被替换的压缩协程。
+// 压缩完成的回调需要 fileMutex，调用方需持有 fileMutex，并在释放后关闭返回的压缩协程
+func (fw *FileWriter) setCompressor(c *Compressor) *compressWorker {
+	old := fw.compressor
+	fw.compressor = nil
+	if c != nil {
+		fw.compressor = newCompressWorker(c)
+		fw.compressor.onError = fw.reportError
+		fw.compressor.onDone = fw.compressDone
+	}
+	return old
+}
+
+// compressDone 一个备份压缩完成(err 为 nil)或失败后在压缩协程中调用：
+// 归档压缩后的文件，并按保留策略清理备份
+func (fw *FileWriter) compressDone(src, dst string, err error) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if err == nil && fw.archiver != nil {
+		fw.archiver.compressed(src, dst)
+	}
+	if perr := fw.prune(); perr != nil {
+		fw.reportError(perr)
+	}
+}
+
+// queued 判断 path 是否在压缩队列中或正在压缩
+func (cw *compressWorker) queued(path string) bool {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.current == path {
+		return true
+	}
+	for _, job := range cw.pending {
+		if job.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue 将备份文件加入压缩队列，不会阻塞
+func (cw *compressWorker) enqueue(fs FileSystem, path string) {
+	cw.once.Do(func() { go cw.run() })
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.closed {
+		return
+	}
+	cw.pending = append(cw.pending, compressJob{fs: fs, path: path})
+	cw.idle.Add(1)
+	select {
+	case cw.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// close 等待队列中的文件压缩完成后停止后台协程，可重复调用
+func (cw *compressWorker) close() {
+	cw.mutex.Lock()
+	if cw.closed {
+		cw.mutex.Unlock()
+		return
+	}
+	cw.closed = true
+	cw.mutex.Unlock()
+	cw.idle.Wait()
+	close(cw.wakeup)
+}
+
+// wait 等待队列中的文件全部压缩完成
+func (cw *compressWorker) wait() {
+	cw.idle.Wait()
+}
+
+func (cw *compressWorker) run() {
+	for range cw.wakeup {
+		for {
+			cw.mutex.Lock()
+			if len(cw.pending) == 0 {
+				cw.mutex.Unlock()
+				break
+			}
+			job := cw.pending[0]
+			cw.pending = cw.pending[1:]
+			cw.current = job.path
+			cw.mutex.Unlock()
+
+			err := compressFile(job.fs, cw.compressor, job.path)
+			if err != nil && cw.onError != nil {
+				cw.onError(err)
+			}
+			cw.mutex.Lock()
+			cw.current = ""
+			cw.mutex.Unlock()
+			if cw.onDone != nil {
+				cw.onDone(job.path, job.path+cw.compressor.Ext, err)
+			}
+			cw.idle.Done()
+		}
+	}
+}
+
+// compressFile 将 src 压缩为 src+Ext，先写入临时文件，复制源文件的权限和属主后重命名并删除源文件
+func compressFile(fs FileSystem, c *Compressor, src string) error {
+	dst := src + c.Ext
+	tmp := dst + compressTmpSuffix
+
+	in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fs.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw, err := c.NewWriter(out)
+	if err != nil {
+		out.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		zw.Close()
+		out.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		fs.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	if info, err := in.Stat(); err == nil {
+		if err := copyPerm(fs, info, tmp); err != nil {
+			fs.Remove(tmp)
+			return err
+		}
+	}
+	if err := fs.Rename(tmp, dst); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return fs.Remove(src)
+}
+
+// compressorOf 返回当前的压缩算法，调用方需持有 fileMutex
+func (fw *FileWriter) compressorOf() *Compressor {
+	if fw.compressor == nil {
+		return nil
+	}
+	return fw.compressor.compressor
+}
+
+// openBackup 打开备份文件，压缩文件使用 c (后缀为 .gz 时也可以是内置的 gzip)解压
+func openBackup(fs FileSystem, c *Compressor, b backupFile) (io.ReadCloser, error) {
+	f, err := fs.OpenFile(b.path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if b.ext == "" {
+		return f, nil
+	}
+	if c == nil || c.Ext != b.ext {
+		c = GzipCompressor
+	}
+	if c.Ext != b.ext || c.NewReader == nil {
+		f.Close()
+		return nil, fmt.Errorf("logwriter: no reader for compressed file %s", b.path)
+	}
+	zr, err := c.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decompressReader{ReadCloser: zr, file: f}, nil
+}
+
+// decompressReader 关闭时同时关闭解压 reader 和文件
+type decompressReader struct {
+	io.ReadCloser
+	file File
+}
+
+func (r *decompressReader) Close() error {
+	err := r.ReadCloser.Close()
+	if ferr := r.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// recoverCompress 处理上次进程退出时未完成的压缩：
+// 删除残留的临时文件，已有压缩文件的备份直接删除源文件，其余备份重新加入压缩队列
+func (fw *FileWriter) recoverCompress() error {
+	if fw.compressor == nil {
+		return nil
+	}
+	ext := fw.compressor.compressor.Ext
+	fs := fw.filesystem()
+	infos, err := fs.ReadDir(fw.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	m := fw.template().matcher(fw.name, fw.location())
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasSuffix(name, ext+compressTmpSuffix) {
+			if _, _, _, _, ok := m.match(strings.TrimSuffix(name, ext+compressTmpSuffix)); ok {
+				fs.Remove(filepath.Join(fw.dir, name))
+			}
+			continue
+		}
+		if _, _, _, e, ok := m.match(name); ok && e == "" && names[name+ext] {
+			path := filepath.Join(fw.dir, name)
+			fs.Remove(path)
+			if fw.archiver != nil {
+				fw.archiver.compressed(path, path+ext)
+			}
+		}
+	}
+	backups, err := fw.listBackups()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		if b.ext != "" {
+			continue
+		}
+		// 压缩完成后归档压缩文件
+		if fw.archiver != nil {
+			fw.archiver.remove(b.path)
+		}
+		fw.compressor.enqueue(fs, b.path)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCompressRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 未开始压缩的备份
+	ioutil.WriteFile(filepath.Join(dir, "2019-01-01.000.log"), []byte("first\n"), 0644)
+	// 压缩中断，残留临时文件
+	ioutil.WriteFile(filepath.Join(dir, "2019-01-01.001.log"), []byte("second\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "2019-01-01.001.log.gz.tmp"), []byte("garbage"), 0644)
+	// 压缩完成但源文件未删除
+	ioutil.WriteFile(filepath.Join(dir, "2019-01-01.002.log"), []byte("third\n"), 0644)
+	if err := compressFile(OSFileSystem{}, GzipCompressor, filepath.Join(dir, "2019-01-01.002.log")); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "2019-01-01.002.log"), []byte("third\n"), 0644)
+
+	fw := &FileWriter{dir: dir, name: defName}
+	fw.SetCompressor(GzipCompressor)
+	if err := fw.recoverCompress(); err != nil {
+		t.Fatal(err)
+	}
+	fw.compressor.wait()
+
+	names := listNames(t, dir)
+	if len(names) != 3 {
+		t.Fatalf("unexpected files %v", names)
+	}
+	for name, want := range map[string]string{
+		"2019-01-01.000.log.gz": "first\n",
+		"2019-01-01.001.log.gz": "second\n",
+		"2019-01-01.002.log.gz": "third\n",
+	} {
+		if got := readGzip(t, filepath.Join(dir, name)); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCompressSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fw, _ := NewDateSplitWriter()
+	fw.SetDir(dir)
+	fw.SetCompressor(GzipCompressor)
+	for i := 0; i < 3; i++ {
+		fw.Write([]byte("hello world\n"))
+		fw.rotate(TriggerManual)
+	}
+	fw.compressor.wait()
+
+	backups, err := fw.listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("got %d backups, want 3", len(backups))
+	}
+	for i, b := range backups {
+		if b.seq != i || b.ext != ".gz" {
+			t.Errorf("unexpected backup %s", b.path)
+		}
+		if got := readGzip(t, b.path); got != "hello world\n" {
+			t.Errorf("%s: got %q", b.path, got)
+		}
+	}
+}
+
+func TestCompressRetention(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(6)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	release := make(chan struct{})
+	writer.SetCompressor(&Compressor{
+		Ext: ".gz",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			<-release
+			return gzip.NewWriter(w), nil
+		},
+	})
+	writer.SetRetention(RetentionPolicy{MaxBackups: 1})
+	var mutex sync.Mutex
+	var errs []error
+	writer.SetErrorHandler(func(err error) {
+		mutex.Lock()
+		errs = append(errs, err)
+		mutex.Unlock()
+	})
+
+	// 压缩进行中时不删除队列中的备份，压缩完成后再按保留策略清理
+	for _, line := range []string{"aaaaa\n", "bbbbb\n", "ccccc\n", "ddddd\n"} {
+		mustWrite(t, writer, line)
+	}
+	close(release)
+	writer.Close()
+	mutex.Lock()
+	if len(errs) != 0 {
+		t.Errorf("got errors %v", errs)
+	}
+	mutex.Unlock()
+	names, _ := fs.ReadDir(defDir)
+	var got []string
+	for _, info := range names {
+		if !strings.HasPrefix(info.Name(), ".") {
+			got = append(got, info.Name())
+		}
+	}
+	if strings.Join(got, ",") != "2019-06-01.003.log.gz,"+defName {
+		t.Errorf("got files %v", got)
+	}
+}
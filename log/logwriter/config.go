@@ -0,0 +1,518 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config FileWriter 的声明式配置，包括切分方式、备份保留、压缩、权限和命名，
+// 可以从 JSON 或 YAML 加载，零值字段使用默认值
+//
+//	dir: /var/log/app
+//	name: app.log
+//	split:
+//	  type: any
+//	  policies:
+//	    - {type: size, size: 100MB}
+//	    - {type: daily}
+//	retention: {max_backups: 30, max_age: 720h}
+//	compress: gzip
+//	backup_template: "{name}-{time:20060102}.{seq:3}{ext}"
+type Config struct {
+	// Dir 日志目录，默认 "logs"
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// Name 日志文件名，默认 "default.log"
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// CheckInterval 后台切分检查间隔，默认 5 分钟
+	CheckInterval Duration `json:"check_interval,omitempty" yaml:"check_interval,omitempty"`
+	// Split 切分方式，默认按日期切分
+	Split SplitConfig `json:"split,omitempty" yaml:"split,omitempty"`
+	// Retention 备份文件保留策略
+	Retention RetentionConfig `json:"retention,omitempty" yaml:"retention,omitempty"`
+	// Compress 备份文件压缩算法，"" 或 "none" 不压缩，"gzip" 使用 GzipCompressor
+	Compress string `json:"compress,omitempty" yaml:"compress,omitempty"`
+	// BackupTemplate 备份文件命名模板，默认值见 SetBackupTemplate
+	BackupTemplate string `json:"backup_template,omitempty" yaml:"backup_template,omitempty"`
+	// CurrentLink 指向当前日志文件的符号链接，为空时不创建
+	CurrentLink string `json:"current_link,omitempty" yaml:"current_link,omitempty"`
+	// Fallback 日志文件不可写时的降级方式："none"、"stderr" 或 "drop"
+	Fallback string `json:"fallback,omitempty" yaml:"fallback,omitempty"`
+	// Sync 刷盘策略
+	Sync SyncConfig `json:"sync,omitempty" yaml:"sync,omitempty"`
+	// DirMode 创建日志目录时使用的权限，如 "0750"，默认 0777(受 umask 影响)
+	DirMode FileMode `json:"dir_mode,omitempty" yaml:"dir_mode,omitempty"`
+	// FileMode 创建日志文件时使用的权限，如 "0640"，默认 0666(受 umask 影响)
+	FileMode FileMode `json:"file_mode,omitempty" yaml:"file_mode,omitempty"`
+	// UID、GID 日志目录和日志文件的属主和属组，未配置时不修改
+	UID *int `json:"uid,omitempty" yaml:"uid,omitempty"`
+	GID *int `json:"gid,omitempty" yaml:"gid,omitempty"`
+	// ReadOnlyBackups 备份后去掉备份文件的写权限
+	ReadOnlyBackups bool `json:"read_only_backups,omitempty" yaml:"read_only_backups,omitempty"`
+	// SharedRotation 多个进程写入同一个日志文件时通过锁文件协调切分，见 SetSharedRotation
+	SharedRotation bool `json:"shared_rotation,omitempty" yaml:"shared_rotation,omitempty"`
+}
+
+// SplitConfig 切分方式
+type SplitConfig struct {
+	// Type 切分方式：
+	// "date"、"daily" 按日期，"hourly" 按整点，"size" 按大小，"time"、"interval" 按时间间隔，
+	// "cron" 按 cron 表达式，"reopen" 不切分(配合外部 logrotate)，
+	// "any"、"all" 组合 Policies 中的条件
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Size 按大小切分时的文件大小，如 100MB
+	Size ByteSize `json:"size,omitempty" yaml:"size,omitempty"`
+	// Interval 按时间间隔切分时的间隔，如 1h
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Cron 按 cron 表达式切分时的表达式
+	Cron string `json:"cron,omitempty" yaml:"cron,omitempty"`
+	// Policies 组合切分的子条件
+	Policies []SplitConfig `json:"policies,omitempty" yaml:"policies,omitempty"`
+}
+
+// RetentionConfig 备份文件保留策略，见 RetentionPolicy
+type RetentionConfig struct {
+	MaxBackups int      `json:"max_backups,omitempty" yaml:"max_backups,omitempty"`
+	MaxAge     Duration `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	MaxSize    ByteSize `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+}
+
+// SyncConfig 刷盘策略，见 SyncPolicy
+type SyncConfig struct {
+	// Mode "never"、"write"、"bytes" 或 "interval"
+	Mode     string   `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Bytes    ByteSize `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+}
+
+// Duration 配置中的时间间隔，可以写作 "90s"、"1h30m" 或纳秒数
+type Duration time.Duration
+
+// UnmarshalJSON 实现 json.Unmarshaler 接口
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return d.set(v)
+}
+
+// MarshalJSON 实现 json.Marshaler 接口
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalYAML 实现 yaml.Unmarshaler 接口
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return d.set(v)
+}
+
+// MarshalYAML 实现 yaml.Marshaler 接口
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) set(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		t, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("logwriter: invalid duration %q", v)
+		}
+		*d = Duration(t)
+	case float64:
+		*d = Duration(v)
+	case int:
+		*d = Duration(v)
+	default:
+		return fmt.Errorf("logwriter: invalid duration %v", v)
+	}
+	return nil
+}
+
+// ByteSize 配置中的字节数，可以写作 "512KB"、"100MB"、"1GB" 或整数，单位按 1024 进制计算
+type ByteSize int64
+
+// UnmarshalJSON 实现 json.Unmarshaler 接口
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return s.set(v)
+}
+
+// UnmarshalYAML 实现 yaml.Unmarshaler 接口
+func (s *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return s.set(v)
+}
+
+func (s *ByteSize) set(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		n, err := parseByteSize(v)
+		if err != nil {
+			return err
+		}
+		*s = ByteSize(n)
+	case float64:
+		*s = ByteSize(v)
+	case int:
+		*s = ByteSize(v)
+	default:
+		return fmt.Errorf("logwriter: invalid size %v", v)
+	}
+	return nil
+}
+
+// FileMode 配置中的文件权限，字符串按八进制解析，如 "0640"
+type FileMode os.FileMode
+
+// UnmarshalJSON 实现 json.Unmarshaler 接口
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return m.set(v)
+}
+
+// UnmarshalYAML 实现 yaml.Unmarshaler 接口
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return m.set(v)
+}
+
+func (m *FileMode) set(v interface{}) error {
+	var mode int64
+	switch v := v.(type) {
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 8, 32)
+		if err != nil {
+			return fmt.Errorf("logwriter: invalid file mode %q", v)
+		}
+		mode = n
+	case float64:
+		mode = int64(v)
+	case int:
+		mode = int64(v)
+	default:
+		return fmt.Errorf("logwriter: invalid file mode %v", v)
+	}
+	if mode < 0 || mode > int64(os.ModePerm) {
+		return fmt.Errorf("logwriter: invalid file mode %o", mode)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+func parseByteSize(text string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(text))
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	unit := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("logwriter: invalid size %q", text)
+	}
+	return n * unit, nil
+}
+
+// ParseConfig 解析 JSON 或 YAML 格式的配置，未知字段视为错误
+func ParseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("logwriter: parse config: %v", err)
+		}
+		return cfg, nil
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("logwriter: parse config: %v", err)
+	}
+	return cfg, nil
+}
+
+// LoadConfig 读取 JSON 或 YAML 格式的配置文件
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v (%s)", err, filepath.Base(path))
+	}
+	return cfg, nil
+}
+
+// NewConfigWriter 返回按配置创建的日志文件 writer
+func NewConfigWriter(cfg *Config) (*FileWriter, error) {
+	w, _ := NewDateSplitWriter()
+	if err := w.Apply(cfg); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// appliedConfig 校验通过、可以直接生效的配置
+type appliedConfig struct {
+	dir, name     string
+	checkInterval time.Duration
+	splitType     FileSplitType
+	splitSize     int64
+	splitTime     time.Duration
+	policy        SplitPolicy
+	retention     RetentionPolicy
+	compressor    *Compressor
+	template      *BackupTemplate
+	currentLink   string
+	fallback      FallbackMode
+	sync          SyncPolicy
+	perm          Permissions
+	shared        bool
+}
+
+// build 校验配置并填充默认值
+func (cfg *Config) build() (*appliedConfig, error) {
+	a := &appliedConfig{
+		dir:           cfg.Dir,
+		name:          cfg.Name,
+		checkInterval: time.Duration(cfg.CheckInterval),
+		currentLink:   cfg.CurrentLink,
+		shared:        cfg.SharedRotation,
+		perm: Permissions{
+			DirMode:         os.FileMode(cfg.DirMode),
+			FileMode:        os.FileMode(cfg.FileMode),
+			UID:             cfg.UID,
+			GID:             cfg.GID,
+			ReadOnlyBackups: cfg.ReadOnlyBackups,
+		},
+		retention: RetentionPolicy{
+			MaxBackups: cfg.Retention.MaxBackups,
+			MaxAge:     time.Duration(cfg.Retention.MaxAge),
+			MaxSize:    int64(cfg.Retention.MaxSize),
+		},
+	}
+	if a.dir == "" {
+		a.dir = defDir
+	}
+	if a.name == "" {
+		a.name = defName
+	}
+	if a.checkInterval <= 0 {
+		a.checkInterval = defCheckInterval
+	}
+
+	switch strings.ToLower(cfg.Split.Type) {
+	case "", "date":
+		a.splitType = STypeDate
+	case "size":
+		if cfg.Split.Size <= 0 {
+			return nil, fmt.Errorf("logwriter: split type size requires size")
+		}
+		a.splitType, a.splitSize = STypeSize, int64(cfg.Split.Size)
+	case "time":
+		if cfg.Split.Interval <= 0 {
+			return nil, fmt.Errorf("logwriter: split type time requires interval")
+		}
+		a.splitType, a.splitTime = STypeTime, time.Duration(cfg.Split.Interval)
+	case "reopen":
+		a.splitType = STypeReopen
+	default:
+		p, err := cfg.Split.policy()
+		if err != nil {
+			return nil, err
+		}
+		a.splitType, a.policy = STypePolicy, p
+	}
+
+	switch strings.ToLower(cfg.Compress) {
+	case "", "none":
+	case "gzip", "gz":
+		a.compressor = GzipCompressor
+	default:
+		return nil, fmt.Errorf("logwriter: unknown compression %q", cfg.Compress)
+	}
+
+	if cfg.BackupTemplate != "" {
+		t, err := ParseBackupTemplate(cfg.BackupTemplate)
+		if err != nil {
+			return nil, err
+		}
+		a.template = t
+	}
+
+	switch strings.ToLower(cfg.Fallback) {
+	case "", "none":
+		a.fallback = FallbackNone
+	case "stderr":
+		a.fallback = FallbackStderr
+	case "drop":
+		a.fallback = FallbackDrop
+	default:
+		return nil, fmt.Errorf("logwriter: unknown fallback %q", cfg.Fallback)
+	}
+
+	switch strings.ToLower(cfg.Sync.Mode) {
+	case "", "never":
+		a.sync = SyncPolicy{Mode: SyncNever}
+	case "write":
+		a.sync = SyncPolicy{Mode: SyncEveryWrite}
+	case "bytes":
+		if cfg.Sync.Bytes <= 0 {
+			return nil, fmt.Errorf("logwriter: sync mode bytes requires bytes")
+		}
+		a.sync = SyncPolicy{Mode: SyncEveryBytes, Bytes: int64(cfg.Sync.Bytes)}
+	case "interval":
+		if cfg.Sync.Interval <= 0 {
+			return nil, fmt.Errorf("logwriter: sync mode interval requires interval")
+		}
+		a.sync = SyncPolicy{Mode: SyncInterval, Interval: time.Duration(cfg.Sync.Interval)}
+	default:
+		return nil, fmt.Errorf("logwriter: unknown sync mode %q", cfg.Sync.Mode)
+	}
+	return a, nil
+}
+
+// policy 将切分配置转换为 SplitPolicy
+func (c SplitConfig) policy() (SplitPolicy, error) {
+	switch strings.ToLower(c.Type) {
+	case "date", "daily":
+		return DailyPolicy(), nil
+	case "hourly":
+		return HourlyPolicy(), nil
+	case "size":
+		if c.Size <= 0 {
+			return nil, fmt.Errorf("logwriter: split type size requires size")
+		}
+		return SizePolicy(int64(c.Size)), nil
+	case "time", "interval":
+		if c.Interval <= 0 {
+			return nil, fmt.Errorf("logwriter: split type %s requires interval", c.Type)
+		}
+		return IntervalPolicy(time.Duration(c.Interval)), nil
+	case "cron":
+		return CronPolicy(c.Cron)
+	case "any", "all":
+		if len(c.Policies) == 0 {
+			return nil, fmt.Errorf("logwriter: split type %s requires policies", c.Type)
+		}
+		policies := make([]SplitPolicy, 0, len(c.Policies))
+		for _, sub := range c.Policies {
+			p, err := sub.policy()
+			if err != nil {
+				return nil, err
+			}
+			policies = append(policies, p)
+		}
+		if strings.ToLower(c.Type) == "any" {
+			return AnyOf(policies...), nil
+		}
+		return AllOf(policies...), nil
+	default:
+		return nil, fmt.Errorf("logwriter: unknown split type %q", c.Type)
+	}
+}
+
+// Apply 按配置重新设置 FileWriter，可以在写入过程中调用。
+// 配置先整体校验(开启多进程共享时防篡改模式、文件头和文件尾需要未开启)，有错误时不做任何修改；校验通过后在持有内部锁时一次性生效，
+// 不会与并发的写入交错，已写入的数据不会丢失或重复：
+// 切换切分方式后当前文件保留，下次写入时按新的条件判断切分；
+// 日志目录或文件名变化时关闭当前文件，下次写入时在新位置创建，待归档队列随之移到新位置；
+// 后台切分检查的间隔在下次检查后生效
+func (fw *FileWriter) Apply(cfg *Config) error {
+	a, err := cfg.build()
+	if err != nil {
+		return err
+	}
+	fw.fileMutex.Lock()
+	if fw.closed {
+		fw.fileMutex.Unlock()
+		return ErrClosed
+	}
+	if a.shared && fw.perProcessContent() {
+		fw.fileMutex.Unlock()
+		return ErrSharedConflict
+	}
+	if a.dir != fw.dir || a.name != fw.name {
+		if fw.file != nil {
+			if err := fw.file.Sync(); err != nil {
+				fw.reportError(err)
+			}
+			if err := fw.file.Close(); err != nil {
+				fw.reportError(err)
+			}
+			fw.file = nil
+		}
+		fw.dir, fw.name = a.dir, a.name
+		fw.recovered = false
+	}
+	fw.checkInterval = a.checkInterval
+	fw.splitType = a.splitType
+	fw.splitSize = a.splitSize
+	fw.splitTime = a.splitTime
+	fw.policy = a.policy
+	fw.retention = a.retention
+	fw.backupTemplate = a.template
+	fw.fallback = a.fallback
+	fw.perm = a.perm
+	if fw.shared && !a.shared {
+		fw.closeLock()
+	}
+	fw.shared = a.shared
+	var oldCompressor *compressWorker
+	if fw.compressorOf() != a.compressor {
+		oldCompressor = fw.setCompressor(a.compressor)
+	}
+	if a.currentLink != fw.currentLink {
+		fw.currentLink = a.currentLink
+		if fw.file != nil {
+			if err := fw.updateLink(); err != nil {
+				fw.reportError(err)
+			}
+		}
+	}
+	syncChanged := fw.syncPolicy != a.sync
+	fw.syncPolicy = a.sync
+	fw.fileMutex.Unlock()
+
+	// 被替换的压缩协程在释放 fileMutex 后等待压缩完成，不阻塞写入
+	if oldCompressor != nil {
+		oldCompressor.close()
+	}
+
+	if syncChanged && a.sync.Mode == SyncInterval {
+		fw.startSyncLoop(a.sync.Interval)
+	}
+	return nil
+}
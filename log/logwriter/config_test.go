@@ -0,0 +1,228 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	yamlText := `
+dir: /var/log/app
+name: app.log
+check_interval: 30s
+split:
+  type: any
+  policies:
+    - {type: size, size: 10MB}
+    - {type: cron, cron: "0 * * * *"}
+retention: {max_backups: 7, max_age: 168h, max_size: 1GB}
+compress: gzip
+backup_template: "{name}-{time:20060102}.{seq:3}{ext}"
+fallback: stderr
+sync: {mode: bytes, bytes: 64KB}
+dir_mode: 0750
+file_mode: "0640"
+uid: 1000
+gid: 0
+read_only_backups: true
+`
+	jsonText := `{
+	"dir": "/var/log/app", "name": "app.log", "check_interval": "30s",
+	"split": {"type": "any", "policies": [{"type": "size", "size": "10MB"}, {"type": "cron", "cron": "0 * * * *"}]},
+	"retention": {"max_backups": 7, "max_age": "168h", "max_size": "1GB"},
+	"compress": "gzip", "backup_template": "{name}-{time:20060102}.{seq:3}{ext}",
+	"fallback": "stderr", "sync": {"mode": "bytes", "bytes": 65536},
+	"dir_mode": "0750", "file_mode": "0640", "uid": 1000, "gid": 0, "read_only_backups": true
+}`
+	for name, text := range map[string]string{"yaml": yamlText, "json": jsonText} {
+		cfg, err := ParseConfig([]byte(text))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		a, err := cfg.build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if a.dir != "/var/log/app" || a.name != "app.log" || a.checkInterval != 30*time.Second {
+			t.Errorf("%s: got dir %q name %q check interval %v", name, a.dir, a.name, a.checkInterval)
+		}
+		if a.splitType != STypePolicy || a.policy == nil {
+			t.Errorf("%s: got split type %d policy %v", name, a.splitType, a.policy)
+		}
+		if want := (RetentionPolicy{MaxBackups: 7, MaxAge: 168 * time.Hour, MaxSize: 1 << 30}); a.retention != want {
+			t.Errorf("%s: got retention %+v, want %+v", name, a.retention, want)
+		}
+		if a.compressor != GzipCompressor || a.template == nil || a.fallback != FallbackStderr {
+			t.Errorf("%s: got compressor %v template %v fallback %v", name, a.compressor, a.template, a.fallback)
+		}
+		if want := (SyncPolicy{Mode: SyncEveryBytes, Bytes: 64 << 10}); a.sync != want {
+			t.Errorf("%s: got sync %+v, want %+v", name, a.sync, want)
+		}
+		if p := a.perm; p.DirMode != 0750 || p.FileMode != 0640 || !p.ReadOnlyBackups {
+			t.Errorf("%s: got permissions %+v", name, p)
+		}
+		if p := a.perm; p.UID == nil || *p.UID != 1000 || p.GID == nil || *p.GID != 0 {
+			t.Errorf("%s: got owner %v:%v, want 1000:0", name, p.UID, p.GID)
+		}
+	}
+
+	for _, text := range []string{
+		"unknown: 1",
+		`{"unknown": 1}`,
+		"split: {type: size}",
+		"split: {type: weekly}",
+		"split: {type: size, size: 10XB}",
+		"split: {type: any, policies: [{type: cron, cron: bad}]}",
+		"compress: zstd",
+		"check_interval: soon",
+		"file_mode: \"0999\"",
+	} {
+		cfg, err := ParseConfig([]byte(text))
+		if err == nil {
+			_, err = cfg.build()
+		}
+		if err == nil {
+			t.Errorf("%q: got nil error", text)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.yaml")
+	text := fmt.Sprintf("dir: %s\nsplit: {type: size, size: 4}\n", filepath.Join(dir, "logs"))
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := NewConfigWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, writer, "abcdef")
+	writer.Close()
+	names := listNames(t, filepath.Join(dir, "logs"))
+	delete(names, "."+defName+stateSuffix)
+	if !names[defName] || len(names) != 2 {
+		t.Errorf("got files %v, want %s and one backup", names, defName)
+	}
+}
+
+func TestApply(t *testing.T) {
+	writer, _ := NewDateSplitWriter()
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "0123456789")
+	// 切换为按大小切分，已写入的内容超过上限，下次写入前切分
+	if err := writer.Apply(&Config{Split: SplitConfig{Type: "size", Size: 8}}); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, writer, "ab")
+	checkFiles(t, fs, defDir, map[string]string{"2019-06-01.000.log": "0123456789", defName: "ab"})
+
+	// 非法配置不做任何修改
+	if err := writer.Apply(&Config{Split: SplitConfig{Type: "cron", Cron: "bad"}}); err == nil {
+		t.Error("Apply: got nil error for invalid cron")
+	}
+	mustWrite(t, writer, "cdefgh")
+	checkFiles(t, fs, defDir, map[string]string{"2019-06-01.000.log": "0123456789", "2019-06-01.001.log": "abcdefgh", defName: ""})
+
+	// 切换为按时间间隔切分
+	if err := writer.Apply(&Config{Split: SplitConfig{Type: "interval", Interval: Duration(time.Minute)}}); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, writer, "0123456789")
+	clock.Add(time.Minute)
+	mustWrite(t, writer, "x")
+	if trigger := writer.LastTrigger(); trigger != TriggerInterval {
+		t.Errorf("LastTrigger: got %q, want %q", trigger, TriggerInterval)
+	}
+
+	// 修改文件名后在新位置创建文件，旧文件保留
+	if err := writer.Apply(&Config{Name: "app.log", Split: SplitConfig{Type: "size", Size: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, writer, "y")
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "0123456789",
+		"2019-06-01.001.log": "abcdefgh",
+		"2019-06-01.002.log": "0123456789",
+		defName:              "x",
+		"app.log":            "y",
+	})
+	writer.Close()
+	if err := writer.Apply(&Config{}); err != ErrClosed {
+		t.Errorf("Apply after Close: got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestApplyConcurrent(t *testing.T) {
+	writer, _ := NewDateSplitWriter()
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	writer.SetCheckInterval(time.Millisecond)
+	writer.StartCheck()
+
+	configs := []*Config{
+		{Split: SplitConfig{Type: "size", Size: 60}},
+		{Split: SplitConfig{Type: "any", Policies: []SplitConfig{{Type: "size", Size: 102}, {Type: "hourly"}}}},
+		{Split: SplitConfig{Type: "date"}, CheckInterval: Duration(2 * time.Millisecond)},
+		{Split: SplitConfig{Type: "reopen"}},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				mustWrite(t, writer, fmt.Sprintf("%d-%03d\n", i, j))
+			}
+		}(i)
+	}
+	for i := 0; i < 40; i++ {
+		if err := writer.Apply(configs[i%len(configs)]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+	writer.Close()
+
+	infos, err := fs.ReadDir(defDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		data, _ := fs.ReadFile(filepath.Join(defDir, info.Name()))
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if line != "" {
+				seen[line]++
+			}
+		}
+	}
+	if len(seen) != 800 {
+		t.Errorf("got %d distinct lines, want 800", len(seen))
+	}
+	for line, n := range seen {
+		if n != 1 {
+			t.Errorf("line %q written %d times", line, n)
+		}
+	}
+}
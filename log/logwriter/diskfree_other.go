@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package log
+
+import (
+	"errors"
+)
+
+// diskFree 当前平台不支持查询剩余空间
+func diskFree(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("logwriter: statfs is not supported on this platform")
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package log
+
+import (
+	"syscall"
+)
+
+// diskFree 通过 statfs 返回 path 所在卷对非特权用户可用的空间和总空间
+func diskFree(path string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
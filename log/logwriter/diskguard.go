@@ -0,0 +1,214 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defDiskCheckInterval = time.Second * 10
+
+// ErrDiskFull 磁盘空间不足、按 DiskStop 停止写入时返回，数据按 SetFallback 设置的方式处理
+var ErrDiskFull = errors.New("logwriter: not enough free disk space")
+
+// StatFS 由可以查询剩余空间的文件系统实现，OSFileSystem 在 linux 和 darwin 上通过 statfs 实现
+type StatFS interface {
+	// StatFS 返回 path 所在卷的可用空间和总空间 单位 B
+	StatFS(path string) (free, total uint64, err error)
+}
+
+// StatFS 见 StatFS 接口
+func (OSFileSystem) StatFS(path string) (uint64, uint64, error) {
+	return diskFree(path)
+}
+
+// DiskAction 清理备份后剩余空间仍然不足时的处理方式
+type DiskAction int
+
+const (
+	// DiskDrop 丢弃 DiskGuard.Drop 判定为低优先级的数据，其余数据照常写入
+	DiskDrop DiskAction = iota
+	// DiskStop 停止写入，Write 返回 ErrDiskFull
+	DiskStop
+)
+
+// DiskState 磁盘空间状态
+type DiskState int
+
+const (
+	// DiskOK 剩余空间充足
+	DiskOK DiskState = iota
+	// DiskDegraded 剩余空间不足，丢弃低优先级数据
+	DiskDegraded
+	// DiskStopped 剩余空间不足，停止写入
+	DiskStopped
+)
+
+func (s DiskState) String() string {
+	switch s {
+	case DiskOK:
+		return "ok"
+	case DiskDegraded:
+		return "degraded"
+	case DiskStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// DiskStatus 磁盘空间状态变化时传给回调的信息
+type DiskStatus struct {
+	// State 当前状态
+	State DiskState
+	// Free 日志目录所在卷的可用空间 单位 B
+	Free uint64
+	// Total 日志目录所在卷的总空间 单位 B
+	Total uint64
+	// Pruned 本次检查为释放空间删除的备份文件数
+	Pruned int
+}
+
+// DiskGuard 磁盘空间保护
+type DiskGuard struct {
+	// MinFree 日志目录所在卷的可用空间低于该值(单位 B)时，先从最旧的备份开始删除，
+	// 仍然不足时按 Action 处理
+	MinFree uint64
+	// Action 清理备份后空间仍然不足时的处理方式，默认 DiskDrop
+	Action DiskAction
+	// Drop DiskDrop 模式下判断一次写入的数据是否丢弃，默认丢弃包含 "DEBUG" 的数据
+	Drop func(p []byte) bool
+	// CheckInterval 两次检查剩余空间的最小间隔，检查在写入时进行，默认 10 秒
+	CheckInterval time.Duration
+	// OnStatus 状态变化时调用，在持有 FileWriter 内部锁时被调用，不能在其中写入同一个 FileWriter
+	OnStatus func(DiskStatus)
+}
+
+// diskGuard 磁盘空间保护的运行状态
+type diskGuard struct {
+	DiskGuard
+	state     DiskState
+	lastCheck time.Time
+	checked   bool
+}
+
+// SetDiskGuard 开启磁盘空间保护，g 为 nil 时关闭。
+// 文件系统需要实现 StatFS，无法查询剩余空间时通过错误回调报告并照常写入
+func (fw *FileWriter) SetDiskGuard(g *DiskGuard) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if g == nil {
+		fw.diskGuard = nil
+		return
+	}
+	guard := &diskGuard{DiskGuard: *g}
+	if guard.Drop == nil {
+		guard.Drop = dropDebug
+	}
+	if guard.CheckInterval <= 0 {
+		guard.CheckInterval = defDiskCheckInterval
+	}
+	fw.diskGuard = guard
+}
+
+// DiskState 返回当前的磁盘空间状态
+func (fw *FileWriter) DiskState() DiskState {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.diskGuard == nil {
+		return DiskOK
+	}
+	return fw.diskGuard.state
+}
+
+func dropDebug(p []byte) bool {
+	return bytes.Contains(p, []byte("DEBUG"))
+}
+
+// admit 按磁盘空间状态判断 p 是否写入，丢弃时计入丢弃字节数，
+// 停止写入时返回 ErrDiskFull，调用方需持有 fileMutex
+func (fw *FileWriter) admit(p []byte) (bool, error) {
+	g := fw.diskGuard
+	if g == nil {
+		return true, nil
+	}
+	if now := fw.now(); !g.checked || !now.Before(g.lastCheck.Add(g.CheckInterval)) {
+		g.checked = true
+		g.lastCheck = now
+		fw.checkDisk()
+	}
+	switch g.state {
+	case DiskDegraded:
+		if g.Drop(p) {
+			fw.dropped += int64(len(p))
+			return false, nil
+		}
+	case DiskStopped:
+		return false, ErrDiskFull
+	}
+	return true, nil
+}
+
+// statFS 返回 dir 所在文件系统的剩余空间和总空间，dir 尚未创建时按最近的已存在的上级目录统计
+func statFS(sfs StatFS, dir string) (uint64, uint64, error) {
+	for {
+		free, total, err := sfs.StatFS(dir)
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return free, total, err
+		}
+		dir = parent
+	}
+}
+
+// checkDisk 检查剩余空间，不足时删除最旧的备份，状态变化时调用回调，调用方需持有 fileMutex
+func (fw *FileWriter) checkDisk() {
+	g := fw.diskGuard
+	sfs, ok := fw.filesystem().(StatFS)
+	if !ok {
+		fw.reportError(errors.New("logwriter: file system does not support StatFS"))
+		return
+	}
+	free, total, err := statFS(sfs, fw.dir)
+	if err != nil {
+		fw.reportError(err)
+		return
+	}
+	pruned := 0
+	if free < g.MinFree {
+		backups, err := fw.listBackups()
+		if err != nil && !os.IsNotExist(err) {
+			fw.reportError(err)
+		}
+		for _, b := range backups {
+			if free >= g.MinFree {
+				break
+			}
+			if err := fw.filesystem().Remove(b.path); err != nil {
+				fw.reportError(err)
+				continue
+			}
+			pruned++
+			if free, total, err = statFS(sfs, fw.dir); err != nil {
+				fw.reportError(err)
+				return
+			}
+		}
+	}
+	state := DiskOK
+	if free < g.MinFree {
+		state = DiskDegraded
+		if g.Action == DiskStop {
+			state = DiskStopped
+		}
+	}
+	if state == g.state && pruned == 0 {
+		return
+	}
+	g.state = state
+	if g.OnStatus != nil {
+		g.OnStatus(DiskStatus{State: state, Free: free, Total: total, Pruned: pruned})
+	}
+}
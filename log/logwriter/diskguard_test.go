@@ -0,0 +1,93 @@
+package log
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskGuard(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(100)
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs.MkdirAll(defDir, 0777)
+	for _, name := range []string{"2019-05-30.000.log", "2019-05-31.000.log"} {
+		fs.WriteFile(filepath.Join(defDir, name), make([]byte, 100), 0644)
+	}
+	fs.SetCapacity(400)
+
+	var statuses []DiskStatus
+	writer.SetDiskGuard(&DiskGuard{
+		MinFree:       250,
+		CheckInterval: time.Minute,
+		OnStatus:      func(s DiskStatus) { statuses = append(statuses, s) },
+	})
+
+	// 先删除最旧的备份
+	mustWrite(t, writer, "INFO start\n")
+	if len(statuses) != 1 || statuses[0].State != DiskOK || statuses[0].Pruned != 1 {
+		t.Fatalf("got statuses %+v", statuses)
+	}
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-05-31.000.log": string(make([]byte, 100)),
+		defName:              "INFO start\n",
+	})
+
+	// 备份删完后空间仍不足，丢弃 DEBUG 日志
+	fs.WriteFile("other", make([]byte, 200), 0644)
+	clock.Add(time.Minute)
+	mustWrite(t, writer, "DEBUG noisy\n")
+	mustWrite(t, writer, "ERROR kept\n")
+	if s := statuses[len(statuses)-1]; s.State != DiskDegraded || s.Pruned != 1 {
+		t.Fatalf("got status %+v", s)
+	}
+	if writer.DiskState() != DiskDegraded || writer.Dropped() != int64(len("DEBUG noisy\n")) {
+		t.Errorf("got state %v, dropped %d", writer.DiskState(), writer.Dropped())
+	}
+	checkFiles(t, fs, defDir, map[string]string{defName: "INFO start\nERROR kept\n"})
+
+	// 停止写入
+	writer.SetDiskGuard(&DiskGuard{MinFree: 250, Action: DiskStop})
+	if _, err := writer.Write([]byte("ERROR lost\n")); err != ErrDiskFull {
+		t.Errorf("got %v, want ErrDiskFull", err)
+	}
+
+	// 空间恢复后照常写入
+	fs.Remove("other")
+	writer.SetDiskGuard(&DiskGuard{MinFree: 250, Action: DiskStop})
+	mustWrite(t, writer, "INFO recovered\n")
+	if writer.DiskState() != DiskOK {
+		t.Errorf("got state %v, want ok", writer.DiskState())
+	}
+	writer.Close()
+}
+
+func TestDiskGuardMissingDir(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(100)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs.SetCapacity(10)
+	var errs []error
+	writer.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	writer.SetDiskGuard(&DiskGuard{MinFree: 20, Action: DiskStop})
+
+	// 日志目录尚未创建时按上级目录检查剩余空间
+	if _, err := writer.Write([]byte("ERROR lost\n")); err != ErrDiskFull {
+		t.Errorf("got %v, want ErrDiskFull", err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("got errors %v", errs)
+	}
+	writer.Close()
+}
+
+func TestOSStatFS(t *testing.T) {
+	free, total, err := OSFileSystem{}.StatFS(".")
+	if err != nil {
+		t.Skip(err)
+	}
+	if total == 0 || free > total {
+		t.Errorf("got free %d, total %d", free, total)
+	}
+	if _, _, err := statFS(OSFileSystem{}, filepath.Join("missing", "logs")); err != nil {
+		t.Errorf("missing dir: got %v", err)
+	}
+}
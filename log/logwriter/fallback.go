@@ -0,0 +1,75 @@
+package log
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// FallbackMode 日志文件不可写时的降级方式
+type FallbackMode int
+
+const (
+	// FallbackNone 不降级，Write 返回错误
+	FallbackNone FallbackMode = iota
+	// FallbackStderr 改为写入标准错误输出
+	FallbackStderr
+	// FallbackDrop 丢弃日志并累计丢弃的字节数
+	FallbackDrop
+)
+
+// SetErrorHandler 设置错误回调，创建、切分、清理、压缩日志文件出错时调用
+// 回调可能在持有 FileWriter 内部锁时被调用，不能在回调中再写入同一个 FileWriter
+func (fw *FileWriter) SetErrorHandler(h func(error)) {
+	fw.errorHandler.Store(h)
+}
+
+// SetFallback 设置日志文件不可写时的降级方式，默认 FallbackNone
+func (fw *FileWriter) SetFallback(mode FallbackMode) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.fallback = mode
+}
+
+// Dropped 返回累计丢弃的字节数，包括 FallbackDrop 模式下丢弃的和异步写入队列溢出丢弃的
+func (fw *FileWriter) Dropped() int64 {
+	fw.fileMutex.Lock()
+	dropped := fw.dropped
+	fw.fileMutex.Unlock()
+	if fw.async != nil {
+		dropped += fw.async.droppedBytes()
+	}
+	return dropped
+}
+
+func (fw *FileWriter) reportError(err error) {
+	atomic.AddInt64(&fw.errorCount, 1)
+	if h, ok := fw.errorHandler.Load().(func(error)); ok && h != nil {
+		h(err)
+	}
+}
+
+// writeFailed 报告写入错误并按降级方式处理 p[n:] 中剩余的数据，调用方需持有 fileMutex
+// 磁盘空间不足已通过磁盘空间状态回调报告，不再调用错误回调
+func (fw *FileWriter) writeFailed(p []byte, n int, err error) (int, error) {
+	fw.metrics.errors++
+	if err != ErrDiskFull {
+		fw.reportError(err)
+	}
+	return fw.fallbackWrite(p, n, err)
+}
+
+// fallbackWrite 处理写入失败后 p[n:] 中剩余的数据，调用方需持有 fileMutex
+func (fw *FileWriter) fallbackWrite(p []byte, n int, err error) (int, error) {
+	switch fw.fallback {
+	case FallbackStderr:
+		if _, serr := os.Stderr.Write(p[n:]); serr != nil {
+			return n, err
+		}
+		return len(p), nil
+	case FallbackDrop:
+		fw.dropped += int64(len(p) - n)
+		return len(p), nil
+	default:
+		return n, err
+	}
+}
@@ -0,0 +1,55 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// 日志目录的父路径是普通文件，创建目录必然失败
+	blocker := filepath.Join(dir, "blocker")
+	ioutil.WriteFile(blocker, nil, 0644)
+
+	writer, _ := NewDateSplitWriter()
+	writer.SetDir(filepath.Join(blocker, "logs"))
+	var errs []error
+	writer.SetErrorHandler(func(err error) {
+		errs = append(errs, err)
+	})
+
+	if err := writer.SyncWriter(); err == nil {
+		t.Error("SyncWriter: expected error")
+	}
+	if n, err := writer.Write([]byte("hello world\n")); err == nil || n != 0 {
+		t.Errorf("Write: got (%d, %v), want error", n, err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("error handler called %d times, want 2", len(errs))
+	}
+
+	writer.SetFallback(FallbackDrop)
+	if n, err := writer.Write([]byte("hello world\n")); err != nil || n != 12 {
+		t.Errorf("Write with FallbackDrop: got (%d, %v)", n, err)
+	}
+	if d := writer.Dropped(); d != 12 {
+		t.Errorf("Dropped: got %d, want 12", d)
+	}
+
+	// 目录恢复后重新创建日志文件
+	os.Remove(blocker)
+	if _, err := writer.Write([]byte("recovered\n")); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(blocker, "logs", defName))
+	if string(data) != "recovered\n" {
+		t.Errorf("live file: got %q", data)
+	}
+	writer.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package log
+
+import (
+	"errors"
+	"os"
+)
+
+// flock 当前平台不支持咨询锁
+func flock(f *os.File, mode lockMode) error {
+	return errors.New("logwriter: flock is not supported on this platform")
+}
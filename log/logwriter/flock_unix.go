@@ -0,0 +1,26 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package log
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock 通过 flock 将 f 上的锁改为 mode，被信号中断时重试
+func flock(f *os.File, mode lockMode) error {
+	how := syscall.LOCK_UN
+	switch mode {
+	case lockShared:
+		how = syscall.LOCK_SH
+	case lockExclusive:
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
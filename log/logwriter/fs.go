@@ -0,0 +1,137 @@
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Clock 时钟，用于判断切分条件和清理过期备份
+type Clock interface {
+	Now() time.Time
+}
+
+// File FileWriter 使用的文件
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// FileSystem FileWriter 使用的文件系统操作，默认使用操作系统的文件系统
+type FileSystem interface {
+	MkdirAll(path string, perm os.FileMode) error
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+}
+
+// OSFileSystem 操作系统的文件系统
+type OSFileSystem struct{}
+
+// MkdirAll 见 os.MkdirAll
+func (OSFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// OpenFile 见 os.OpenFile
+func (OSFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat 见 os.Stat
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir 见 ioutil.ReadDir
+func (OSFileSystem) ReadDir(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dir)
+}
+
+// Rename 见 os.Rename
+func (OSFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove 见 os.Remove
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Symlink 见 os.Symlink
+func (OSFileSystem) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+// Readlink 见 os.Readlink
+func (OSFileSystem) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// SetClock 设置时钟，默认使用系统时间
+func (fw *FileWriter) SetClock(c Clock) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.clock = c
+}
+
+// SetFileSystem 设置文件系统，默认 OSFileSystem，需要在首次写入前调用
+func (fw *FileWriter) SetFileSystem(fs FileSystem) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.fs = fs
+}
+
+func (fw *FileWriter) now() time.Time {
+	if fw.clock == nil {
+		return time.Now()
+	}
+	return fw.clock.Now()
+}
+
+// location 返回时钟所在的时区，备份文件名中的时间按该时区生成和解析
+func (fw *FileWriter) location() *time.Location {
+	return fw.now().Location()
+}
+
+func (fw *FileWriter) filesystem() FileSystem {
+	if fw.fs == nil {
+		return OSFileSystem{}
+	}
+	return fw.fs
+}
+
+// readFile 读取整个文件
+func readFile(fs FileSystem, name string) ([]byte, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// writeFile 写入整个文件，文件已存在时覆盖
+func writeFile(fs FileSystem, name string, data []byte, perm os.FileMode) error {
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
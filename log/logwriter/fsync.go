@@ -0,0 +1,123 @@
+package log
+
+import (
+	"os"
+	"time"
+)
+
+// SyncMode 日志文件刷盘的时机
+type SyncMode int
+
+const (
+	// SyncNever 不主动刷盘，由操作系统决定，Close 时刷盘
+	SyncNever SyncMode = iota
+	// SyncEveryWrite 每次写入后刷盘
+	SyncEveryWrite
+	// SyncEveryBytes 累计写入 Bytes 字节后刷盘
+	SyncEveryBytes
+	// SyncInterval 每隔 Interval 刷盘一次，期间没有写入时不刷盘
+	SyncInterval
+)
+
+// SyncPolicy 刷盘策略
+type SyncPolicy struct {
+	// Mode 刷盘时机
+	Mode SyncMode
+	// Bytes SyncEveryBytes 模式下两次刷盘之间写入的字节数
+	Bytes int64
+	// Interval SyncInterval 模式下的刷盘间隔
+	Interval time.Duration
+}
+
+// SetSyncPolicy 设置刷盘策略，默认 SyncNever。
+// 除 SyncNever 外，切分时还会在重命名前将文件刷盘，并在创建新文件后将日志目录刷盘，保证重命名在掉电后仍然有效。
+// SyncInterval 模式下启动后台协程定时刷盘，Stop、Close 时退出
+func (fw *FileWriter) SetSyncPolicy(p SyncPolicy) {
+	fw.fileMutex.Lock()
+	fw.syncPolicy = p
+	fw.fileMutex.Unlock()
+	if p.Mode == SyncInterval && p.Interval > 0 {
+		fw.startSyncLoop(p.Interval)
+	}
+}
+
+// startSyncLoop 启动定时刷盘协程，已有的定时刷盘协程先退出，同时只有一个协程在运行
+func (fw *FileWriter) startSyncLoop(interval time.Duration) {
+	fw.lifeMutex.Lock()
+	defer fw.lifeMutex.Unlock()
+	if fw.stopped {
+		return
+	}
+	if fw.stopCh == nil {
+		fw.stopCh = make(chan struct{})
+	}
+	if fw.syncStop != nil {
+		close(fw.syncStop)
+	}
+	fw.syncStop = make(chan struct{})
+	stopCh, syncStop := fw.stopCh, fw.syncStop
+	ticker := time.NewTicker(interval)
+	fw.checkWG.Add(1)
+	go func() {
+		defer fw.checkWG.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fw.fileMutex.Lock()
+				p := fw.syncPolicy
+				if p.Mode == SyncInterval && p.Interval == interval && fw.unsynced > 0 && !fw.closed {
+					if err := fw.syncFile(); err != nil {
+						fw.reportError(err)
+					}
+				}
+				fw.fileMutex.Unlock()
+				if p.Mode != SyncInterval || p.Interval != interval {
+					return
+				}
+			case <-stopCh:
+				return
+			case <-syncStop:
+				return
+			}
+		}
+	}()
+}
+
+// afterWrite 记录写入的字节数并按刷盘策略刷盘，调用方需持有 fileMutex
+func (fw *FileWriter) afterWrite(n int) error {
+	fw.unsynced += int64(n)
+	p := fw.syncPolicy
+	switch {
+	case fw.unsynced == 0:
+		return nil
+	case p.Mode == SyncEveryWrite,
+		p.Mode == SyncEveryBytes && fw.unsynced >= p.Bytes,
+		p.Mode == SyncInterval && !fw.now().Before(fw.lastSync.Add(p.Interval)):
+		return fw.syncFile()
+	}
+	return nil
+}
+
+// syncFile 将当前日志文件刷盘，调用方需持有 fileMutex
+func (fw *FileWriter) syncFile() error {
+	if fw.file == nil {
+		return nil
+	}
+	fw.unsynced = 0
+	fw.lastSync = fw.now()
+	return fw.file.Sync()
+}
+
+// syncDir 将目录刷盘，使其中的创建、重命名操作持久化
+func syncDir(fs FileSystem, dir string) error {
+	d, err := fs.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package log
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncCountFS 统计每个路径的刷盘次数
+type syncCountFS struct {
+	*MemFS
+	mutex sync.Mutex
+	syncs map[string]int
+}
+
+func (fs *syncCountFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncCountFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *syncCountFS) count(name string) int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.syncs[name]
+}
+
+type syncCountFile struct {
+	File
+	fs   *syncCountFS
+	name string
+}
+
+func (f *syncCountFile) Sync() error {
+	f.fs.mutex.Lock()
+	f.fs.syncs[f.name]++
+	f.fs.mutex.Unlock()
+	return f.File.Sync()
+}
+
+func TestSyncPolicy(t *testing.T) {
+	current := defDir + "/" + defName
+	for _, tc := range []struct {
+		policy SyncPolicy
+		want   int
+	}{
+		{SyncPolicy{Mode: SyncNever}, 0},
+		{SyncPolicy{Mode: SyncEveryWrite}, 4},
+		{SyncPolicy{Mode: SyncEveryBytes, Bytes: 10}, 2},
+		{SyncPolicy{Mode: SyncInterval, Interval: time.Minute}, 2},
+	} {
+		clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+		fs := &syncCountFS{MemFS: NewMemFS(clock), syncs: make(map[string]int)}
+		writer, _ := NewSizeSplitWriter(1000)
+		writer.SetClock(clock)
+		writer.SetFileSystem(fs)
+		writer.SetSyncPolicy(tc.policy)
+		for i := 0; i < 4; i++ {
+			mustWrite(t, writer, "line\n")
+			clock.Add(40 * time.Second)
+		}
+		if got := fs.count(current); got != tc.want {
+			t.Errorf("%+v: got %d syncs, want %d", tc.policy, got, tc.want)
+		}
+		writer.Close()
+	}
+}
+
+func TestSyncOnRotate(t *testing.T) {
+	clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs := &syncCountFS{MemFS: NewMemFS(clock), syncs: make(map[string]int)}
+	writer, _ := NewSizeSplitWriter(5)
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	writer.SetSyncPolicy(SyncPolicy{Mode: SyncEveryBytes, Bytes: 1 << 20})
+	mustWrite(t, writer, "line\nline\n")
+	if got := fs.count(defDir + "/" + defName); got != 2 {
+		t.Errorf("file: got %d syncs, want 2", got)
+	}
+	if got := fs.count(defDir); got != 2 {
+		t.Errorf("dir: got %d syncs, want 2", got)
+	}
+	writer.Close()
+}
+
+func TestSyncLoopRestart(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(1000)
+	newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	policy := SyncPolicy{Mode: SyncInterval, Interval: time.Hour}
+	writer.SetSyncPolicy(policy)
+	writer.lifeMutex.Lock()
+	first := writer.syncStop
+	writer.lifeMutex.Unlock()
+
+	// 重复设置相同的策略时只保留一个定时刷盘协程
+	writer.SetSyncPolicy(policy)
+	select {
+	case <-first:
+	default:
+		t.Error("first sync loop still running")
+	}
+	writer.Close()
+}
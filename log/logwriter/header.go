@@ -0,0 +1,225 @@
+package log
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HeaderInfo 创建日志文件时传给文件头生成函数的信息
+type HeaderInfo struct {
+	// Host 主机名
+	Host string
+	// Process 进程名
+	Process string
+	// PID 进程号
+	PID int
+	// Version 由 SetVersion 设置的版本号
+	Version string
+	// File 新日志文件的路径
+	File string
+	// PrevFile 上一个日志文件的备份路径，没有时为空；开启压缩时为压缩后的路径，压缩失败时该文件不存在，备份保留原名
+	PrevFile string
+	// Time 创建时间
+	Time time.Time
+}
+
+// FooterInfo 切分日志文件时传给文件尾生成函数的信息
+type FooterInfo struct {
+	// File 备份前日志文件的路径
+	File string
+	// Records 文件中的记录数，不含文件头。按记录写入时为写入的记录数，否则为行数
+	Records int64
+	// Size 文件大小，含文件头，不含防篡改模式的结束标记和文件尾
+	Size int64
+	// SHA256 文件内容(含文件头，不含防篡改模式的结束标记和文件尾)的 SHA-256，十六进制编码
+	SHA256 string
+	// Time 切分时间
+	Time time.Time
+}
+
+// SetHeader 设置文件头生成函数，每次创建新的日志文件时将其返回值写在文件开头，
+// 以追加方式重新打开已有文件时不再写入，已开启多进程共享时返回 ErrSharedConflict。
+// 生成函数在持有 FileWriter 内部锁时被调用，不能在其中写入同一个 FileWriter
+func (fw *FileWriter) SetHeader(fn func(HeaderInfo) []byte) error {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fn != nil && fw.shared {
+		return ErrSharedConflict
+	}
+	fw.header = fn
+	return nil
+}
+
+// SetFooter 设置文件尾生成函数，切分时将其返回值写在被备份文件的末尾。
+// 当前日志文件在 Close 时不写文件尾，重新打开后继续追加，已开启多进程共享时返回 ErrSharedConflict。
+// 生成函数在持有 FileWriter 内部锁时被调用，不能在其中写入同一个 FileWriter
+func (fw *FileWriter) SetFooter(fn func(FooterInfo) []byte) error {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fn != nil && fw.shared {
+		return ErrSharedConflict
+	}
+	fw.footer = fn
+	fw.digest = nil
+	if fn != nil && fw.file != nil {
+		if err := fw.resumeDigest(); err != nil {
+			fw.reportError(err)
+		}
+	}
+	return nil
+}
+
+// SetVersion 设置写入文件头的版本号
+func (fw *FileWriter) SetVersion(version string) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.version = version
+}
+
+// DefaultHeader 以一行 "# key=value ..." 的形式输出文件头，没有上一个文件时 prev 为 "-"
+func DefaultHeader(h HeaderInfo) []byte {
+	prev := "-"
+	if h.PrevFile != "" {
+		prev = filepath.Base(h.PrevFile)
+	}
+	return []byte(fmt.Sprintf("# host=%s process=%s pid=%d version=%s prev=%s time=%s\n",
+		h.Host, h.Process, h.PID, h.Version, prev, h.Time.Format(time.RFC3339Nano)))
+}
+
+// DefaultFooter 以一行 "# key=value ..." 的形式输出文件尾
+func DefaultFooter(f FooterInfo) []byte {
+	return []byte(fmt.Sprintf("# records=%d size=%d sha256=%s time=%s\n",
+		f.Records, f.Size, f.SHA256, f.Time.Format(time.RFC3339Nano)))
+}
+
+// writeHeader 在新创建的日志文件开头写入文件头，调用方需持有 fileMutex
+func (fw *FileWriter) writeHeader() error {
+	fw.digest = nil
+	fw.fileRecords = 0
+	if fw.footer != nil {
+		fw.digest = sha256.New()
+	}
+	fw.headerSize = 0
+	if fw.header == nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	prev := fw.prevFile
+	if prev == "" {
+		if backups, err := fw.listBackups(); err == nil && len(backups) > 0 {
+			prev = backups[len(backups)-1].path
+		}
+	}
+	data := fw.header(HeaderInfo{
+		Host:     host,
+		Process:  filepath.Base(os.Args[0]),
+		PID:      os.Getpid(),
+		Version:  fw.version,
+		File:     fw.fileName,
+		PrevFile: prev,
+		Time:     fw.createTime,
+	})
+	n, err := fw.file.Write(data)
+	fw.size += int64(n)
+	fw.headerSize = int64(n)
+	fw.track(data[:n], 0)
+	return err
+}
+
+// footerData 返回当前日志文件的文件尾，没有设置文件尾生成函数时返回 nil，调用方需持有 fileMutex
+func (fw *FileWriter) footerData() []byte {
+	if fw.footer == nil {
+		return nil
+	}
+	info := FooterInfo{
+		File:    fw.fileName,
+		Records: fw.fileRecords,
+		Size:    fw.size,
+		Time:    fw.now(),
+	}
+	if fw.digest != nil {
+		info.SHA256 = hex.EncodeToString(fw.digest.Sum(nil))
+	}
+	return fw.footer(info)
+}
+
+// writeTrailer 以追加方式打开已重命名的备份文件，写入防篡改模式的结束标记和文件尾。
+// 重命名失败时不写入，避免它们留在继续写入的日志文件中间，调用方需持有 fileMutex
+func (fw *FileWriter) writeTrailer(backupName string) error {
+	if fw.footer == nil && fw.chain == nil {
+		return nil
+	}
+	f, err := fw.filesystem().OpenFile(backupName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	fw.file = f
+	footer := fw.footerData()
+	if fw.chain != nil {
+		err = fw.writeChainEnd(footer)
+	}
+	if err == nil && len(footer) > 0 {
+		_, err = f.Write(footer)
+	}
+	if err == nil && fw.syncPolicy.Mode != SyncNever {
+		err = fw.syncFile()
+	}
+	fw.file = nil
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// track 统计写入当前日志文件的内容，records 为写入的记录数，
+// 为 -1 时按换行符统计，调用方需持有 fileMutex
+func (fw *FileWriter) track(p []byte, records int64) {
+	if fw.digest == nil {
+		return
+	}
+	fw.digest.Write(p)
+	if records < 0 {
+		records = int64(bytes.Count(p, []byte{'\n'}))
+	}
+	fw.fileRecords += records
+}
+
+// resumeDigest 以追加方式重新打开已有文件时，读取已有内容恢复摘要和记录数，
+// 已有记录按换行符统计，调用方需持有 fileMutex
+func (fw *FileWriter) resumeDigest() error {
+	fw.digest = sha256.New()
+	fw.fileRecords = 0
+	f, err := fw.filesystem().OpenFile(fw.fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := fw.headerSize
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.Read(buf)
+		fw.digest.Write(buf[:n])
+		data := buf[:n]
+		if header > 0 {
+			skip := header
+			if skip > int64(n) {
+				skip = int64(n)
+			}
+			data = data[skip:]
+			header -= skip
+		}
+		fw.fileRecords += int64(bytes.Count(data, []byte{'\n'}))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeaderFooter(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(40)
+	writer.SetVersion("v1.2.0")
+	writer.SetHeader(func(h HeaderInfo) []byte {
+		return []byte(fmt.Sprintf("# %s prev=%s\n", h.Version, filepath.Base(h.PrevFile)))
+	})
+	writer.SetFooter(DefaultFooter)
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "aaa\n")
+	writer.Close()
+
+	// 重新打开后从已有内容恢复摘要和记录数
+	writer, _ = NewSizeSplitWriter(40)
+	writer.SetVersion("v1.2.0")
+	writer.SetHeader(func(h HeaderInfo) []byte {
+		return []byte(fmt.Sprintf("# %s prev=%s\n", h.Version, filepath.Base(h.PrevFile)))
+	})
+	writer.SetFooter(DefaultFooter)
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	mustWrite(t, writer, "bbb\n")
+	mustWrite(t, writer, "ccccccccccccccc\n")
+	mustWrite(t, writer, "ddd\n")
+	writer.Close()
+
+	first, err := fs.ReadFile(filepath.Join(defDir, "2019-06-01.000.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "# v1.2.0 prev=.\naaa\nbbb\nccccccccccccccc\n"
+	sum := sha256.Sum256([]byte(content))
+	footer := fmt.Sprintf("# records=3 size=%d sha256=%s time=%s\n",
+		len(content), hex.EncodeToString(sum[:]), clock.Now().Format(time.RFC3339Nano))
+	if string(first) != content+footer {
+		t.Errorf("backup: got %q, want %q", first, content+footer)
+	}
+
+	current, _ := fs.ReadFile(filepath.Join(defDir, defName))
+	if want := "# v1.2.0 prev=2019-06-01.000.log\nddd\n"; string(current) != want {
+		t.Errorf("current: got %q, want %q", current, want)
+	}
+}
+
+func TestHeaderPrevCompressed(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(40)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	writer.SetHeader(func(h HeaderInfo) []byte {
+		return []byte(fmt.Sprintf("prev=%s\n", filepath.Base(h.PrevFile)))
+	})
+	writer.SetCompressor(GzipCompressor)
+
+	// 开启压缩时文件头记录压缩后的备份名
+	mustWrite(t, writer, strings.Repeat("a", 33))
+	mustWrite(t, writer, "b")
+	writer.Close()
+	current, _ := fs.ReadFile(filepath.Join(defDir, defName))
+	if want := "prev=2019-06-01.000.log.gz\nb"; string(current) != want {
+		t.Errorf("current: got %q, want %q", current, want)
+	}
+	if _, err := fs.Stat(filepath.Join(defDir, "2019-06-01.000.log.gz")); err != nil {
+		t.Error(err)
+	}
+}
+
+// failRenameFS 重命名失败指定次数
+type failRenameFS struct {
+	*MemFS
+	failures int
+}
+
+func (fs *failRenameFS) Rename(oldpath, newpath string) error {
+	if fs.failures > 0 {
+		fs.failures--
+		return errors.New("rename failed")
+	}
+	return fs.MemFS.Rename(oldpath, newpath)
+}
+
+func TestFooterAfterFailedRename(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(12)
+	writer.SetFooter(DefaultFooter)
+	clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	fs := &failRenameFS{MemFS: NewMemFS(clock), failures: 1}
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	var errs []error
+	writer.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	mustWrite(t, writer, "aaaaaaa\n")
+	// 写满后切分，重命名失败时继续写入原文件，不写入文件尾
+	mustWrite(t, writer, "bbb\n")
+	mustWrite(t, writer, "ccc\n")
+	writer.Close()
+	if len(errs) != 1 {
+		t.Errorf("got errors %v, want the rename error", errs)
+	}
+
+	content := "aaaaaaa\nbbb\n"
+	sum := sha256.Sum256([]byte(content))
+	footer := fmt.Sprintf("# records=2 size=%d sha256=%s time=%s\n",
+		len(content), hex.EncodeToString(sum[:]), clock.Now().Format(time.RFC3339Nano))
+	checkFiles(t, fs.MemFS, defDir, map[string]string{"2019-06-01.000.log": content + footer, defName: "ccc\n"})
+}
+
+func TestDefaultHeader(t *testing.T) {
+	h := DefaultHeader(HeaderInfo{Host: "node1", Process: "app", PID: 42, Version: "v1",
+		Time: time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)})
+	if want := "# host=node1 process=app pid=42 version=v1 prev=- time=2019-06-01T10:00:00Z\n"; string(h) != want {
+		t.Errorf("got %q, want %q", h, want)
+	}
+	if h := DefaultHeader(HeaderInfo{PrevFile: "logs/2019-06-01.000.log"}); !strings.Contains(string(h), " prev=2019-06-01.000.log ") {
+		t.Errorf("got %q", h)
+	}
+}
@@ -0,0 +1,64 @@
+package log
+
+import (
+	"path/filepath"
+)
+
+// SetCurrentLink 设置指向当前日志文件的符号链接，每次创建日志文件后更新
+// link 为相对路径时相对于日志目录，为空时不创建符号链接
+func (fw *FileWriter) SetCurrentLink(link string) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.currentLink = link
+	if fw.file != nil {
+		if err := fw.updateLink(); err != nil {
+			fw.reportError(err)
+		}
+	}
+}
+
+// OnRotate 设置切分回调，每次切分完成后调用，old 为备份文件路径，new 为新的日志文件路径
+// 回调在压缩备份文件之前同步调用，此时 old 一定存在；
+// 回调在持有 FileWriter 内部锁时被调用，不能在回调中再写入同一个 FileWriter，耗时操作应放到其他协程中
+func (fw *FileWriter) OnRotate(fn func(old, new string)) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.onRotate = fn
+}
+
+// linkPath 返回符号链接的路径
+func (fw *FileWriter) linkPath() string {
+	if filepath.IsAbs(fw.currentLink) {
+		return fw.currentLink
+	}
+	return filepath.Join(fw.dir, fw.currentLink)
+}
+
+// updateLink 先创建临时符号链接再重命名，保证读取方任何时候都能看到有效的链接
+func (fw *FileWriter) updateLink() error {
+	if fw.currentLink == "" {
+		return nil
+	}
+	link := fw.linkPath()
+	target, err := filepath.Abs(fw.fileName)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(link) == filepath.Dir(fw.fileName) {
+		target = filepath.Base(fw.fileName)
+	}
+	fs := fw.filesystem()
+	if current, err := fs.Readlink(link); err == nil && current == target {
+		return nil
+	}
+	tmp := link + ".tmp"
+	fs.Remove(tmp)
+	if err := fs.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmp, link); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCurrentLinkAndOnRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewSizeSplitWriter(6)
+	writer.SetDir(dir)
+	writer.SetCurrentLink("current")
+	type event struct{ old, new, content string }
+	var events []event
+	writer.OnRotate(func(old, new string) {
+		data, _ := ioutil.ReadFile(old)
+		events = append(events, event{old, new, string(data)})
+	})
+	writer.Write([]byte("first\nsecond\n"))
+	writer.Close()
+
+	link := filepath.Join(dir, "current")
+	if target, err := os.Readlink(link); err != nil || target != defName {
+		t.Errorf("Readlink: got (%q, %v)", target, err)
+	}
+	data, _ := ioutil.ReadFile(link)
+	if string(data) != "\n" {
+		t.Errorf("current: got %q", data)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d rotate events, want 2", len(events))
+	}
+	for i, want := range []string{"first\n", "second"} {
+		if events[i].content != want || events[i].new != filepath.Join(dir, defName) {
+			t.Errorf("event %d: got %+v", i, events[i])
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package log
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"hash"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,8 +18,12 @@ const (
 	defName          = "default.log"
 	defCheckInterval = time.Second * 60 * 5
 	dateFormat       = "2006-01-02"
+	stateSuffix      = ".state"
 )
 
+// ErrClosed 向已关闭的 FileWriter 写入时返回
+var ErrClosed = errors.New("logwriter: writer closed")
+
 // FileSplitType 日志文件的切分方式
 type FileSplitType int
 
@@ -26,20 +34,71 @@ const (
 	STypeSize
 	// STypeTime 按照时间切分
 	STypeTime
+	// STypePolicy 按照 SplitPolicy 组合条件切分
+	STypePolicy
+	// STypeReopen 不切分，由外部 logrotate 移走文件后重新打开，见 NewReopenWriter
+	STypeReopen
 )
 
-// FileWriter 日志文件
+// FileWriter 日志文件，可以被多个协程同时使用
 type FileWriter struct {
-	fileMutex     sync.Mutex
-	file          *os.File
-	fileName      string
-	createTime    time.Time
-	dir           string
-	name          string
-	splitType     FileSplitType
-	checkInterval time.Duration
-	splitSize     int64
-	splitTime     time.Duration
+	// errorCount 错误回调报告的错误数，原子操作，放在开头保证 64 位对齐
+	errorCount     int64
+	fileMutex      sync.Mutex
+	file           File
+	fileName       string
+	createTime     time.Time
+	dir            string
+	name           string
+	splitType      FileSplitType
+	checkInterval  time.Duration
+	splitSize      int64
+	splitTime      time.Duration
+	policy         SplitPolicy
+	lastTrigger    string
+	rotations      map[string]int64
+	size           int64
+	retention      RetentionPolicy
+	compressor     *compressWorker
+	backupTemplate *BackupTemplate
+	recovered      bool
+	closed         bool
+	errorHandler   atomic.Value
+	fallback       FallbackMode
+	dropped        int64
+	async          *asyncQueue
+	currentLink    string
+	onRotate       func(old, new string)
+	clock          Clock
+	fs             FileSystem
+	archiver       *archiver
+	records        *recordBuffer
+	header         func(HeaderInfo) []byte
+	footer         func(FooterInfo) []byte
+	version        string
+	prevFile       string
+	headerSize     int64
+	digest         hash.Hash
+	fileRecords    int64
+	chain          *hashChain
+	diskGuard      *diskGuard
+	reopenChecked  time.Time
+	syncPolicy     SyncPolicy
+	unsynced       int64
+	lastSync       time.Time
+	perm           Permissions
+	shared         bool
+	lock           FileLock
+	lockPath       string
+	lockMode       lockMode
+	metrics        writeMetrics
+
+	lifeMutex sync.Mutex
+	stopCh    chan struct{}
+	stopped   bool
+	checkWG   sync.WaitGroup
+	// syncStop 关闭时当前的定时刷盘协程退出
+	syncStop chan struct{}
 }
 
 // NewDateSplitWriter 返回 根据日期分割的 日志文件 writer
@@ -80,123 +139,567 @@ func NewTimeSplitWriter(d time.Duration) (*FileWriter, error) {
 	return w, nil
 }
 
+// NewPolicySplitWriter 返回根据组合条件分割的 日志文件 writer
+func NewPolicySplitWriter(p SplitPolicy) (*FileWriter, error) {
+	w := &FileWriter{
+		dir:           defDir,
+		name:          defName,
+		splitType:     STypePolicy,
+		checkInterval: defCheckInterval,
+		policy:        p,
+	}
+	return w, nil
+}
+
 // SyncWriter 刷新 wirter 文件相关配置
-func (fw *FileWriter) SyncWriter() {
-	fw.newFile()
+func (fw *FileWriter) SyncWriter() error {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.closed {
+		return ErrClosed
+	}
+	if fw.file != nil {
+		fw.file.Close()
+		fw.file = nil
+	}
+	if err := fw.newFile(); err != nil {
+		fw.reportError(err)
+		return err
+	}
+	return nil
 }
 
 // Write 实现 io.Writer 接口
+// 写入前检查切分条件，按日期或时间切分时在越过边界后的首次写入时切分；
+// 切分条件包含大小时文件恰好写满上限即切分，超出部分写入新文件。
+// 写入失败时调用错误回调，并按 SetFallback 设置的方式降级。
+// 开启磁盘空间保护时剩余空间不足的处理见 SetDiskGuard。
+// 开启异步写入时只将数据放入队列，写入错误通过错误回调报告并由 Flush 返回。
+// 开启按记录写入时未结束的记录先缓存，见 SetRecordMode；
+// 开启防篡改模式时每次写入的数据作为一条记录，见 SetIntegrity
 func (fw *FileWriter) Write(p []byte) (n int, err error) {
+	if fw.records != nil {
+		return fw.writeRecords(p)
+	}
+	if fw.chain != nil {
+		return fw.commitRecord(p)
+	}
+	if fw.async != nil {
+		return fw.async.put(p)
+	}
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.closed {
+		return 0, ErrClosed
+	}
+	if ok, err := fw.admit(p); !ok {
+		if err != nil {
+			return fw.writeFailed(p, 0, err)
+		}
+		return len(p), nil
+	}
+	n, err = fw.write(p)
+	if err != nil {
+		return fw.writeFailed(p, n, err)
+	}
+	return n, nil
+}
+
+// write 写入当前日志文件，调用方需持有 fileMutex
+func (fw *FileWriter) write(p []byte) (n int, err error) {
+	defer fw.observeWrite(time.Now(), &n)
+	release, err := fw.setLock(lockShared)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	if fw.file == nil {
+		if err := fw.newFile(); err != nil {
+			return 0, err
+		}
+	}
+	if err := fw.checkRotate(); err != nil {
+		return 0, err
+	}
+	limit := fw.sizeLimit()
+	if limit <= 0 {
+		n, err = fw.file.Write(p)
+		fw.size += int64(n)
+		fw.track(p[:n], -1)
+		if err != nil {
+			return n, err
+		}
+		return n, fw.afterWrite(n)
+	}
+	for len(p) > 0 {
+		chunk := p
+		if room := limit - fw.size; room > 0 && int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		m, err := fw.file.Write(chunk)
+		n += m
+		fw.size += int64(m)
+		fw.track(chunk[:m], -1)
+		if err != nil {
+			return n, err
+		}
+		if err := fw.afterWrite(m); err != nil {
+			return n, err
+		}
+		p = p[m:]
+		if err := fw.checkRotate(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// checkRotate 满足切分条件时切分，切分失败但仍有可写文件时只上报错误，
+// 没有可写文件时返回错误。STypeReopen 模式下检查文件是否需要重新打开，
+// 多进程共享时先检查日志文件是否已被其他进程切分
+func (fw *FileWriter) checkRotate() error {
+	if fw.splitType == STypeReopen {
+		return fw.checkReopen()
+	}
+	if fw.shared {
+		if err := fw.checkShared(); err != nil {
+			return err
+		}
+	}
+	trigger, ok := fw.checkSplit()
+	if !ok {
+		return nil
+	}
+	return fw.tryRotate(trigger)
+}
+
+// tryRotate 按 trigger 切分，切分失败但仍有可写文件时只上报错误
+func (fw *FileWriter) tryRotate(trigger string) error {
+	err := fw.rotate(trigger)
+	if err == nil {
+		return nil
+	}
 	if fw.file == nil {
-		fw.newFile()
+		return err
 	}
-	return fw.file.Write(p)
+	fw.reportError(err)
+	return nil
 }
 
 // SetDir 设置日志目录，默认 ”logs“
+// 已打开的日志文件在下次 SyncWriter 时生效
 func (fw *FileWriter) SetDir(dir string) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
 	fw.dir = dir
 }
 
 // SetName 设置日志名字，默认 ”default.log“
+// 已打开的日志文件在下次 SyncWriter 时生效
 func (fw *FileWriter) SetName(name string) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
 	fw.name = name
 }
 
-// SetCheckInterval 设置日志分割检查间隔时间
+// SetCheckInterval 设置日志分割检查间隔时间，已启动的检查协程在下次检查后生效
 func (fw *FileWriter) SetCheckInterval(d time.Duration) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
 	fw.checkInterval = d
 }
 
 // StartCheck 启动分割检查
+// Write 时已经会检查切分条件，StartCheck 只用于在长时间没有写入时也能按时切分
 func (fw *FileWriter) StartCheck() {
-	var ticker *time.Ticker
-	if fw.splitType == STypeTime {
-		ticker = time.NewTicker(fw.splitTime)
-	} else {
-		ticker = time.NewTicker(fw.checkInterval)
+	fw.StartCheckContext(context.Background())
+}
+
+// StartCheckContext 启动分割检查，ctx 结束或调用 Stop、Close 后检查协程退出
+func (fw *FileWriter) StartCheckContext(ctx context.Context) {
+	fw.lifeMutex.Lock()
+	defer fw.lifeMutex.Unlock()
+	if fw.stopped {
+		return
 	}
+	if fw.stopCh == nil {
+		fw.stopCh = make(chan struct{})
+	}
+	stopCh := fw.stopCh
 
+	fw.fileMutex.Lock()
+	interval := fw.checkPeriod()
+	fw.fileMutex.Unlock()
+	ticker := time.NewTicker(interval)
+
+	fw.checkWG.Add(1)
 	go func() {
+		defer fw.checkWG.Done()
+		defer func() { ticker.Stop() }()
 		for {
 			select {
 			case <-ticker.C:
-				if fw.splitType == STypeTime {
-					fw.split()
-				} else if fw.checkSplit() {
-					// fmt.Println("split")
-					fw.split()
+				fw.split()
+				// SetCheckInterval、Apply 修改检查间隔后重建 ticker
+				fw.fileMutex.Lock()
+				next := fw.checkPeriod()
+				fw.fileMutex.Unlock()
+				if next != interval {
+					ticker.Stop()
+					interval = next
+					ticker = time.NewTicker(interval)
 				}
+			case <-stopCh:
+				return
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 }
 
-func (fw *FileWriter) checkSplit() bool {
-	switch fw.splitType {
-	case STypeDate:
-		cDate, _ := time.Parse(dateFormat, fw.createTime.Format(dateFormat))
-		nDate, _ := time.Parse(dateFormat, time.Now().Format(dateFormat))
-		// if nDate.Sub(cDate) == time.
-		return nDate.After(cDate)
+// checkPeriod 返回分割检查间隔，按时间切分且切分间隔更短时使用切分间隔，调用方需持有 fileMutex
+func (fw *FileWriter) checkPeriod() time.Duration {
+	interval := fw.checkInterval
+	if fw.splitType == STypeTime && fw.splitTime > 0 && fw.splitTime < interval {
+		interval = fw.splitTime
+	}
+	if interval <= 0 {
+		interval = defCheckInterval
+	}
+	return interval
+}
 
-	case STypeSize:
-		fileInfo, err := os.Stat(fw.fileName)
-		if err != nil {
-			return false
+// Stop 停止分割检查协程并等待其退出，可重复调用
+func (fw *FileWriter) Stop() {
+	fw.lifeMutex.Lock()
+	if !fw.stopped {
+		fw.stopped = true
+		if fw.stopCh != nil {
+			close(fw.stopCh)
 		}
-		return fileInfo.Size() > fw.splitSize
+	}
+	fw.lifeMutex.Unlock()
+	fw.checkWG.Wait()
+}
+
+// Close 实现 io.Closer 接口
+// 停止分割检查，写入未结束的记录，写完异步队列中的数据，等待后台压缩完成，停止归档(未完成的归档在下次启动时继续)，
+// 将当前日志文件刷盘并关闭，可重复调用
+func (fw *FileWriter) Close() error {
+	fw.Stop()
+	fw.flushRecord()
+	if fw.async != nil {
+		fw.async.close()
+	}
+
+	fw.fileMutex.Lock()
+	if fw.closed {
+		fw.fileMutex.Unlock()
+		return nil
+	}
+	fw.closed = true
+	cw := fw.compressor
+	fw.fileMutex.Unlock()
+	// 压缩完成的回调需要 fileMutex
+	if cw != nil {
+		cw.close()
+	}
+
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.archiver != nil {
+		fw.archiver.close()
+	}
+	fw.closeLock()
+	if fw.file == nil {
+		return nil
+	}
+	err := fw.file.Sync()
+	if cerr := fw.file.Close(); err == nil {
+		err = cerr
+	}
+	fw.file = nil
+	return err
+}
 
+// splitPolicy 返回当前的切分条件，未设置 SplitPolicy 时按 splitType 生成
+func (fw *FileWriter) splitPolicy() SplitPolicy {
+	if fw.policy != nil {
+		return fw.policy
+	}
+	switch fw.splitType {
+	case STypeDate:
+		return DailyPolicy()
+	case STypeSize:
+		return SizePolicy(fw.splitSize)
 	case STypeTime:
-		return time.Now().Sub(fw.createTime) > fw.splitTime
+		return IntervalPolicy(fw.splitTime)
 	default:
-		return false
+		return nil
+	}
+}
+
+// sizeLimit 返回切分条件中的文件大小上限，没有大小条件时返回 0
+func (fw *FileWriter) sizeLimit() int64 {
+	if l, ok := fw.splitPolicy().(sizeLimiter); ok {
+		return l.sizeLimit()
+	}
+	return 0
+}
+
+// checkSplit 判断是否需要切分，返回触发的条件名
+func (fw *FileWriter) checkSplit() (string, bool) {
+	p := fw.splitPolicy()
+	if p == nil {
+		return "", false
 	}
+	return p.ShouldSplit(FileState{CreateTime: fw.createTime, Size: fw.size}, fw.now())
 }
 
+// split 满足切分条件时切分日志文件
 func (fw *FileWriter) split() {
 	fw.fileMutex.Lock()
 	defer fw.fileMutex.Unlock()
-	fw.backup()
-	fw.newFile()
+	if fw.closed || fw.file == nil {
+		return
+	}
+	if fw.splitType == STypeReopen {
+		if err := fw.checkReopen(); err != nil {
+			fw.reportError(err)
+		}
+		return
+	}
+	if trigger, ok := fw.checkSplit(); ok {
+		if err := fw.rotate(trigger); err != nil {
+			fw.reportError(err)
+		}
+	}
 }
 
-func (fw *FileWriter) backup() {
-	fw.file.Close()
-	os.Rename(fw.fileName, fw.getBackupName())
+// rotate 备份当前文件并创建新文件，记录触发切分的条件，调用方需持有 fileMutex
+// 备份失败时重新以追加方式打开当前文件，保证日志不丢失。
+// 多进程共享时持有排他锁，日志文件已被其他进程切分时只重新打开
+func (fw *FileWriter) rotate(trigger string) error {
+	release, err := fw.setLock(lockExclusive)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if fw.shared {
+		moved, err := fw.rotatedElsewhere()
+		if err != nil {
+			return err
+		}
+		if moved {
+			return fw.reopen()
+		}
+	}
+	backupName, err := fw.backup()
+	if backupName == "" {
+		if nerr := fw.newFile(); nerr != nil {
+			return nerr
+		}
+		return err
+	}
+	fw.lastTrigger = trigger
+	fw.metrics.lastRotation = fw.now()
+	if fw.rotations == nil {
+		fw.rotations = make(map[string]int64)
+	}
+	fw.rotations[trigger]++
+	fw.prevFile = backupName
+	if fw.compressor != nil {
+		// 新文件的文件头记录压缩后的备份名，压缩在写入文件头之后完成
+		fw.prevFile = backupName + fw.compressor.compressor.Ext
+	}
+	if nerr := fw.newFile(); nerr != nil {
+		return nerr
+	}
+	if fw.syncPolicy.Mode != SyncNever {
+		if serr := syncDir(fw.filesystem(), fw.dir); serr != nil {
+			fw.reportError(serr)
+		}
+	}
+	if fw.onRotate != nil {
+		fw.onRotate(backupName, fw.fileName)
+	}
+	// 开启压缩时在压缩完成后清理，避免删除正在压缩的备份
+	if fw.compressor != nil {
+		fw.compressor.enqueue(fw.filesystem(), backupName)
+	} else {
+		if fw.archiver != nil {
+			fw.archiver.enqueue(backupName)
+		}
+		if perr := fw.prune(); perr != nil {
+			fw.reportError(perr)
+		}
+	}
+	return err
 }
 
-func (fw *FileWriter) getBackupName() string {
-	var count int
-	name := fw.createTime.Format(dateFormat)
-	walkFunc := func(path string, info os.FileInfo, err error) error {
-		if strings.HasPrefix(info.Name(), name) {
-			count++
+// backup 关闭并重命名当前文件，重命名成功后写入结束标记和文件尾，返回备份文件名，重命名失败时备份文件名为空。
+// 按刷盘策略需要刷盘时在关闭前刷盘，设置了 ReadOnlyBackups 时重命名后去掉写权限
+func (fw *FileWriter) backup() (string, error) {
+	if fw.syncPolicy.Mode != SyncNever {
+		if err := fw.syncFile(); err != nil {
+			fw.reportError(err)
 		}
-		return nil
 	}
-	err := filepath.Walk(fw.dir, walkFunc)
+	cerr := fw.file.Close()
+	fw.file = nil
+	backupName := fw.getBackupName()
+	if err := fw.filesystem().Rename(fw.fileName, backupName); err != nil {
+		return "", err
+	}
+	if err := fw.writeTrailer(backupName); err != nil {
+		fw.reportError(err)
+	}
+	if err := fw.protectBackup(backupName); err != nil {
+		fw.reportError(err)
+	}
+	return backupName, cerr
+}
+
+// getBackupName 按命名模板返回下一个备份文件名，
+// 序号取同一时间已有备份(含压缩文件)的最大序号加一
+func (fw *FileWriter) getBackupName() string {
+	t := fw.template()
+	backups, err := fw.listBackups()
 	if err != nil {
-		return filepath.Join(fw.dir, fmt.Sprintf("%s.x.log", name))
+		return filepath.Join(fw.dir, t.format(fw.name, fw.createTime, 0)+".x")
+	}
+	key := fw.createTime.Format(t.timeLayout())
+	next := 0
+	for _, b := range backups {
+		if b.key == key && b.seq >= next {
+			next = b.seq + 1
+		}
 	}
-	// fmt.Println("count:", count)
-	return filepath.Join(fw.dir, fmt.Sprintf("%s.%03d.log", name, count))
+	return filepath.Join(fw.dir, t.format(fw.name, fw.createTime, next))
 }
 
-func (fw *FileWriter) newFile() {
-	if _, err := os.Stat(fw.dir); err != nil {
+// newFile 以追加方式打开当前日志文件，文件不存在时创建并写入文件头
+// 已有文件的创建时间从状态文件中恢复，状态文件不存在时使用文件的修改时间
+func (fw *FileWriter) newFile() error {
+	// 多进程共享时持有排他锁，避免多个进程同时创建日志文件并写入文件头
+	release, err := fw.setLock(lockExclusive)
+	if err != nil {
+		return err
+	}
+	defer release()
+	fs := fw.filesystem()
+	if _, err := fs.Stat(fw.dir); err != nil {
 		if os.IsNotExist(err) {
-			err := os.MkdirAll(fw.dir, 0777)
+			err := fs.MkdirAll(fw.dir, fw.dirPerm())
 			if err != nil {
-				panic(err)
+				return err
+			}
+			if err := fw.applyPerm(fw.dir, fw.perm.DirMode); err != nil {
+				fw.reportError(err)
 			}
 		}
 	}
+	// 在日志目录创建后恢复，日志目录变化时归档队列需要写入新目录
+	if !fw.recovered {
+		fw.recovered = true
+		if err := fw.recoverArchive(); err != nil {
+			fw.reportError(err)
+		}
+		if err := fw.recoverCompress(); err != nil {
+			fw.reportError(err)
+		}
+		if fw.archiver != nil {
+			fw.archiver.launch()
+		}
+	}
 	fw.fileName = filepath.Join(fw.dir, fw.name)
-	file, err := os.Create(fw.fileName)
+	file, err := fs.OpenFile(fw.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, fw.filePerm())
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
 	if err != nil {
-		panic(err)
+		file.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		if err := fw.applyPerm(fw.fileName, fw.perm.FileMode); err != nil {
+			fw.reportError(err)
+		}
 	}
 	fw.file = file
-	fw.createTime = time.Now()
+	fw.size = info.Size()
+	fw.unsynced = 0
+	if err := fw.updateLink(); err != nil {
+		fw.reportError(err)
+	}
+	if fw.size == 0 {
+		fw.createTime = fw.now()
+		if err := fw.writeHeader(); err != nil {
+			fw.reportError(err)
+		}
+		if fw.chain != nil {
+			if err := fw.writeChainStart(); err != nil {
+				fw.reportError(err)
+			}
+		}
+		if err := fw.writeState(); err != nil {
+			fw.reportError(err)
+		}
+		return nil
+	}
+	if t, headerSize, err := fw.readState(); err == nil {
+		fw.createTime = t
+		fw.headerSize = headerSize
+	} else {
+		fw.createTime = info.ModTime()
+		fw.headerSize = 0
+	}
+	fw.digest = nil
+	if fw.footer != nil {
+		if err := fw.resumeDigest(); err != nil {
+			fw.reportError(err)
+		}
+	}
+	if fw.chain != nil {
+		if err := fw.resumeChain(); err != nil {
+			fw.reportError(err)
+		}
+	}
+	return nil
+}
+
+// stateName 返回记录当前日志文件创建时间的状态文件路径
+func (fw *FileWriter) stateName() string {
+	return filepath.Join(fw.dir, "."+fw.name+stateSuffix)
+}
+
+// readState 读取状态文件，第一行为创建时间，第二行(可选)为文件头的字节数
+func (fw *FileWriter) readState() (time.Time, int64, error) {
+	data, err := readFile(fw.filesystem(), fw.stateName())
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(lines[0]))
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	var headerSize int64
+	if len(lines) > 1 {
+		headerSize, _ = strconv.ParseInt(strings.TrimSpace(lines[1]), 10, 64)
+	}
+	return t, headerSize, nil
+}
+
+func (fw *FileWriter) writeState() error {
+	state := fw.createTime.Format(time.RFC3339Nano) + "\n"
+	if fw.headerSize > 0 {
+		state += strconv.FormatInt(fw.headerSize, 10) + "\n"
+	}
+	if err := writeFile(fw.filesystem(), fw.stateName(), []byte(state), fw.filePerm()); err != nil {
+		return err
+	}
+	return fw.applyPerm(fw.stateName(), fw.perm.FileMode)
 }
@@ -1,43 +1,289 @@
 package log
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-func TestSizeSplit(t *testing.T) {
-	writer, err := NewSizeSplitWriter(10000)
+// manualClock 手动调整的时钟
+type manualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{now: now}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newMemWriter 使用内存文件系统和手动时钟初始化 writer
+func newMemWriter(writer *FileWriter, now time.Time) (*MemFS, *manualClock) {
+	clock := newManualClock(now)
+	fs := NewMemFS(clock)
+	writer.SetClock(clock)
+	writer.SetFileSystem(fs)
+	return fs, clock
+}
+
+// checkFiles 检查 dir 中的日志文件(不含状态文件)与 want 完全一致
+func checkFiles(t *testing.T, fs *MemFS, dir string, want map[string]string) {
+	t.Helper()
+	infos, err := fs.ReadDir(dir)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		data, err := fs.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[info.Name()] = string(data)
+	}
+	if len(got) != len(want) {
+		t.Errorf("got files %q, want %q", got, want)
+		return
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s: got %q, want %q", name, got[name], content)
+		}
 	}
-	writer.SetCheckInterval(time.Second * 30)
-	writer.StartCheck()
-	startWrite(writer)
 }
 
-func startWrite(w io.Writer) {
-	for {
-		w.Write([]byte("hello world\n"))
-		time.Sleep(time.Millisecond * 10)
+func mustWrite(t *testing.T, w io.Writer, s string) {
+	t.Helper()
+	if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+		t.Fatalf("Write(%q): got (%d, %v)", s, n, err)
 	}
 }
 
+func TestSizeSplit(t *testing.T) {
+	writer, err := NewSizeSplitWriter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "01234")
+	mustWrite(t, writer, "56789abc")
+	mustWrite(t, writer, "defghijklmnopq")
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "0123456789",
+		"2019-06-01.001.log": "abcdefghij",
+		defName:              "klmnopq",
+	})
+}
+
 func TestTimeSplit(t *testing.T) {
 	writer, err := NewTimeSplitWriter(time.Second * 30)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	writer.StartCheck()
-	startWrite(writer)
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "a\n")
+	clock.Add(29 * time.Second)
+	mustWrite(t, writer, "b\n")
+	checkFiles(t, fs, defDir, map[string]string{defName: "a\nb\n"})
+
+	clock.Add(time.Second)
+	mustWrite(t, writer, "c\n")
+	clock.Add(30 * time.Second)
+	mustWrite(t, writer, "d\n")
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "a\nb\n",
+		"2019-06-01.001.log": "c\n",
+		defName:              "d\n",
+	})
 }
 
 func TestDateSplit(t *testing.T) {
 	writer, err := NewDateSplitWriter()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 23, 59, 59, 0, time.Local))
+
+	mustWrite(t, writer, "a\n")
+	clock.Add(time.Second)
+	mustWrite(t, writer, "b\n")
+	clock.Add(23 * time.Hour)
+	mustWrite(t, writer, "c\n")
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "a\n",
+		defName:              "b\nc\n",
+	})
+
+	clock.Add(time.Hour)
+	mustWrite(t, writer, "d\n")
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "a\n",
+		"2019-06-02.000.log": "b\nc\n",
+		defName:              "d\n",
+	})
+}
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewTimeSplitWriter(time.Millisecond * 10)
+	writer.SetDir(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer.StartCheckContext(ctx)
+	writer.Write([]byte("hello world\n"))
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("after close\n")); err != ErrClosed {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, defName))
+	if string(data) != "hello world\n" {
+		t.Errorf("live file: got %q", data)
+	}
+}
+
+func TestAppendOnRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewTimeSplitWriter(time.Hour)
+	writer.SetDir(dir)
+	writer.Write([]byte("before restart\n"))
+	createTime := writer.createTime
+	writer.Close()
+
+	writer, _ = NewTimeSplitWriter(time.Hour)
+	writer.SetDir(dir)
+	writer.Write([]byte("after restart\n"))
+	if !writer.createTime.Equal(createTime) {
+		t.Errorf("createTime: got %v, want %v", writer.createTime, createTime)
+	}
+	if writer.size != int64(len("before restart\nafter restart\n")) {
+		t.Errorf("size: got %d", writer.size)
+	}
+	writer.Close()
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, defName))
+	if string(data) != "before restart\nafter restart\n" {
+		t.Errorf("live file: got %q", data)
+	}
+
+	// 状态文件丢失时使用文件修改时间
+	os.Remove(filepath.Join(dir, "."+defName+stateSuffix))
+	modTime := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, defName), modTime, modTime)
+	writer, _ = NewTimeSplitWriter(time.Hour)
+	writer.SetDir(dir)
+	writer.Write([]byte("new file\n"))
+	writer.Close()
+
+	backups, _ := writer.listBackups()
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, defName))
+	if string(data) != "new file\n" {
+		t.Errorf("live file: got %q", data)
 	}
-	writer.SetCheckInterval(time.Second * 60)
+}
+
+func TestConcurrentWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const (
+		goroutines = 20
+		lines      = 200
+	)
+	writer, _ := NewSizeSplitWriter(256)
+	writer.SetDir(dir)
+	writer.SetCheckInterval(time.Millisecond)
 	writer.StartCheck()
-	startWrite(writer)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				fmt.Fprintf(writer, "g%02d-%04d\n", g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := writer.listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) < 100 {
+		t.Errorf("got %d backups, want at least 100", len(backups))
+	}
+	var all []byte
+	for _, b := range backups {
+		data, _ := ioutil.ReadFile(b.path)
+		if len(data) != 256 {
+			t.Errorf("%s: got %d bytes, want 256", b.path, len(data))
+		}
+		all = append(all, data...)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, defName))
+	all = append(all, data...)
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(all), "\n"), "\n") {
+		if seen[line] {
+			t.Errorf("duplicate line %q", line)
+		}
+		seen[line] = true
+	}
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < lines; i++ {
+			if line := fmt.Sprintf("g%02d-%04d", g, i); !seen[line] {
+				t.Errorf("missing line %q", line)
+			}
+		}
+	}
 }
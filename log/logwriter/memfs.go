@@ -0,0 +1,421 @@
+package log
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS 内存文件系统，用于测试
+type MemFS struct {
+	mutex    sync.Mutex
+	clock    Clock
+	entries  map[string]*memEntry
+	capacity int64
+	locks    map[string]*memLockState
+}
+
+// memEntry 内存文件系统中的一个文件、目录或符号链接
+type memEntry struct {
+	dir     bool
+	link    string
+	data    []byte
+	perm    os.FileMode
+	uid     int
+	gid     int
+	modTime time.Time
+}
+
+// NewMemFS 返回内存文件系统，clock 用于记录文件修改时间，为 nil 时使用系统时间
+func NewMemFS(clock Clock) *MemFS {
+	return &MemFS{
+		clock: clock,
+		entries: map[string]*memEntry{
+			string(filepath.Separator): {dir: true, perm: os.ModeDir | 0777},
+			".":                        {dir: true, perm: os.ModeDir | 0777},
+		},
+	}
+}
+
+func (m *MemFS) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	return m.clock.Now()
+}
+
+// resolve 跟随符号链接，返回最终路径
+func (m *MemFS) resolve(name string) string {
+	name = filepath.Clean(name)
+	for i := 0; i < 8; i++ {
+		e, ok := m.entries[name]
+		if !ok || e.link == "" {
+			return name
+		}
+		if filepath.IsAbs(e.link) {
+			name = filepath.Clean(e.link)
+		} else {
+			name = filepath.Join(filepath.Dir(name), e.link)
+		}
+	}
+	return name
+}
+
+func (m *MemFS) checkParent(op, name string) error {
+	if e, ok := m.entries[filepath.Dir(name)]; !ok || !e.dir {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+// MkdirAll 见 os.MkdirAll
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	path = filepath.Clean(path)
+	for p := path; ; p = filepath.Dir(p) {
+		if e, ok := m.entries[p]; ok {
+			if !e.dir {
+				return &os.PathError{Op: "mkdir", Path: p, Err: os.ErrExist}
+			}
+			break
+		}
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	for p := path; ; p = filepath.Dir(p) {
+		if _, ok := m.entries[p]; ok {
+			break
+		}
+		m.entries[p] = &memEntry{dir: true, perm: os.ModeDir | perm, modTime: m.now()}
+	}
+	return nil
+}
+
+// OpenFile 见 os.OpenFile，支持 O_RDONLY、O_WRONLY、O_RDWR、O_CREATE、O_EXCL、O_TRUNC、O_APPEND
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = m.resolve(name)
+	e, ok := m.entries[name]
+	switch {
+	case ok && e.dir:
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+		}
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case ok && flag&(os.O_WRONLY|os.O_RDWR) != 0 && e.perm&0200 == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !ok:
+		if err := m.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		e = &memEntry{perm: perm, modTime: m.now()}
+		m.entries[name] = e
+	}
+	if flag&os.O_TRUNC != 0 && !e.dir {
+		e.data = nil
+		e.modTime = m.now()
+	}
+	return &memFile{
+		fs:       m,
+		name:     name,
+		entry:    e,
+		readable: flag&os.O_WRONLY == 0,
+		writable: flag&(os.O_WRONLY|os.O_RDWR) != 0,
+		append:   flag&os.O_APPEND != 0,
+	}, nil
+}
+
+// Stat 见 os.Stat
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = m.resolve(name)
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return e.info(filepath.Base(name)), nil
+}
+
+// ReadDir 见 ioutil.ReadDir，符号链接本身作为目录项返回
+func (m *MemFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	dir = m.resolve(dir)
+	if e, ok := m.entries[dir]; !ok || !e.dir {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: os.ErrNotExist}
+	}
+	var infos []os.FileInfo
+	for name, e := range m.entries {
+		if name != dir && filepath.Dir(name) == dir {
+			infos = append(infos, e.info(filepath.Base(name)))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Rename 见 os.Rename
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	e, ok := m.entries[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if e.dir {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrInvalid}
+	}
+	if err := m.checkParent("rename", newpath); err != nil {
+		return err
+	}
+	if t, ok := m.entries[newpath]; ok && t.dir {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+	delete(m.entries, oldpath)
+	m.entries[newpath] = e
+	return nil
+}
+
+// Remove 见 os.Remove
+func (m *MemFS) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = filepath.Clean(name)
+	e, ok := m.entries[name]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if e.dir {
+		prefix := name + string(filepath.Separator)
+		for other := range m.entries {
+			if strings.HasPrefix(other, prefix) {
+				return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+			}
+		}
+	}
+	delete(m.entries, name)
+	return nil
+}
+
+// Symlink 见 os.Symlink
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	newname = filepath.Clean(newname)
+	if _, ok := m.entries[newname]; ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if err := m.checkParent("symlink", newname); err != nil {
+		return err
+	}
+	m.entries[newname] = &memEntry{link: oldname, perm: os.ModeSymlink | 0777, modTime: m.now()}
+	return nil
+}
+
+// Readlink 见 os.Readlink
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.entries[filepath.Clean(name)]
+	if !ok || e.link == "" {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+	return e.link, nil
+}
+
+// Chtimes 修改文件的修改时间
+func (m *MemFS) Chtimes(name string, mtime time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.entries[m.resolve(name)]
+	if !ok {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	}
+	e.modTime = mtime
+	return nil
+}
+
+// Chmod 修改权限，见 os.Chmod
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.entries[m.resolve(name)]
+	if !ok {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrNotExist}
+	}
+	e.perm = e.perm&^os.ModePerm | mode.Perm()
+	return nil
+}
+
+// Chown 修改属主，见 os.Chown
+func (m *MemFS) Chown(name string, uid, gid int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.entries[m.resolve(name)]
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: os.ErrNotExist}
+	}
+	if uid >= 0 {
+		e.uid = uid
+	}
+	if gid >= 0 {
+		e.gid = gid
+	}
+	return nil
+}
+
+// ReadFile 返回文件内容
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	return readFile(m, name)
+}
+
+// WriteFile 写入文件内容，文件已存在时覆盖
+func (m *MemFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFile(m, name, data, perm)
+}
+
+// StatFS 返回 SetCapacity 设置的容量减去已用空间，未设置容量时可用空间为无限，path 不存在时返回错误
+func (m *MemFS) StatFS(path string) (uint64, uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	path = m.resolve(path)
+	if _, ok := m.entries[path]; !ok && path != filepath.Dir(path) {
+		return 0, 0, &os.PathError{Op: "statfs", Path: path, Err: os.ErrNotExist}
+	}
+	if m.capacity <= 0 {
+		return math.MaxUint64, math.MaxUint64, nil
+	}
+	used := m.used()
+	if used >= m.capacity {
+		return 0, uint64(m.capacity), nil
+	}
+	return uint64(m.capacity - used), uint64(m.capacity), nil
+}
+
+// SetCapacity 设置文件系统的容量 单位 B，写入超过容量时返回错误，<=0 时不限制
+func (m *MemFS) SetCapacity(capacity int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.capacity = capacity
+}
+
+// used 返回所有文件的总大小，调用方需持有 mutex
+func (m *MemFS) used() int64 {
+	var used int64
+	for _, e := range m.entries {
+		used += int64(len(e.data))
+	}
+	return used
+}
+
+func (e *memEntry) info(name string) os.FileInfo {
+	return &memFileInfo{
+		name:    name,
+		size:    int64(len(e.data)),
+		mode:    e.perm,
+		modTime: e.modTime,
+		entry:   e,
+	}
+}
+
+// memFile 内存文件系统中打开的文件
+type memFile struct {
+	fs       *MemFS
+	name     string
+	entry    *memEntry
+	offset   int64
+	readable bool
+	writable bool
+	append   bool
+	closed   bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if f.closed || !f.readable {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.offset >= int64(len(f.entry.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.entry.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if f.closed || !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.append {
+		f.offset = int64(len(f.entry.data))
+	}
+	if gap := f.offset - int64(len(f.entry.data)); gap > 0 {
+		f.entry.data = append(f.entry.data, make([]byte, gap)...)
+	}
+	end := f.offset + int64(len(p))
+	if grow := end - int64(len(f.entry.data)); f.fs.capacity > 0 && grow > 0 && f.fs.used()+grow > f.fs.capacity {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+	}
+	if end > int64(len(f.entry.data)) {
+		f.entry.data = append(f.entry.data[:f.offset], p...)
+	} else {
+		copy(f.entry.data[f.offset:], p)
+	}
+	f.offset = end
+	f.entry.modTime = f.fs.now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+	return f.entry.info(filepath.Base(f.name)), nil
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+// memFileInfo 实现 os.FileInfo
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	entry   *memEntry
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return fi.entry }
@@ -0,0 +1,210 @@
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats FileWriter 的运行统计，可以通过 expvar.Func 发布，或用 MetricsHandler 以文本格式输出
+type Stats struct {
+	// File 当前日志文件的路径
+	File string
+	// Writes 写入次数，开启按记录写入或防篡改模式时为记录数，开启异步写入时为放入队列的次数(不含丢弃的写入)
+	Writes int64
+	// BytesWritten 写入日志文件的字节数，不含文件头、文件尾和防篡改标记行
+	BytesWritten int64
+	// WriteErrors 写入失败的次数，包括磁盘空间不足停止写入
+	WriteErrors int64
+	// Errors 通过错误回调报告的错误数，包括切分、压缩、归档等后台操作的错误
+	Errors int64
+	// Dropped 丢弃的字节数，见 Dropped
+	Dropped int64
+	// WriteLatency 写入耗时之和，包括写入时触发的切分，开启异步写入时为后台写入的耗时
+	WriteLatency time.Duration
+	// MaxWriteLatency 单次写入的最大耗时
+	MaxWriteLatency time.Duration
+	// LastWrite 最近一次写入的时间，尚未写入时为零值
+	LastWrite time.Time
+	// Rotations 按触发条件统计的切分次数
+	Rotations map[string]int64
+	// LastRotation 最近一次切分的时间，尚未切分时为零值
+	LastRotation time.Time
+	// FileSize 当前日志文件的大小
+	FileSize int64
+	// Backups 日志目录中的备份文件数
+	Backups int
+	// BackupBytes 备份文件的总大小
+	BackupBytes int64
+}
+
+// AvgWriteLatency 返回单次写入的平均耗时
+func (s Stats) AvgWriteLatency() time.Duration {
+	if s.Writes == 0 {
+		return 0
+	}
+	return s.WriteLatency / time.Duration(s.Writes)
+}
+
+// writeMetrics 写入统计，由 fileMutex 保护
+type writeMetrics struct {
+	writes       int64
+	bytes        int64
+	errors       int64
+	latency      time.Duration
+	maxLatency   time.Duration
+	lastWrite    time.Time
+	lastRotation time.Time
+}
+
+// observeWrite 记录一次从 start 开始、写入 *n 字节的写入，在写入函数中 defer 调用，调用方需持有 fileMutex
+func (fw *FileWriter) observeWrite(start time.Time, n *int) {
+	latency := time.Since(start)
+	m := &fw.metrics
+	m.writes++
+	m.bytes += int64(*n)
+	m.latency += latency
+	if latency > m.maxLatency {
+		m.maxLatency = latency
+	}
+	m.lastWrite = fw.now()
+}
+
+// Stats 返回运行统计的快照，备份文件数和大小在调用时从日志目录中统计
+func (fw *FileWriter) Stats() Stats {
+	fw.fileMutex.Lock()
+	m := fw.metrics
+	s := Stats{
+		File:            fw.livePath(),
+		Writes:          m.writes,
+		BytesWritten:    m.bytes,
+		WriteErrors:     m.errors,
+		WriteLatency:    m.latency,
+		MaxWriteLatency: m.maxLatency,
+		LastWrite:       m.lastWrite,
+		LastRotation:    m.lastRotation,
+		Rotations:       make(map[string]int64, len(fw.rotations)),
+	}
+	for trigger, n := range fw.rotations {
+		s.Rotations[trigger] = n
+	}
+	fs, dir, name, t, loc := fw.filesystem(), fw.dir, fw.name, fw.template(), fw.location()
+	opened := fw.file != nil
+	if opened {
+		s.FileSize = fw.size
+	}
+	fw.fileMutex.Unlock()
+
+	// 读取目录不持有 fileMutex，避免阻塞写入
+	if !opened {
+		if info, err := fs.Stat(s.File); err == nil {
+			s.FileSize = info.Size()
+		}
+	}
+	if backups, err := listBackups(fs, dir, name, t, loc); err == nil {
+		s.Backups = len(backups)
+		for _, b := range backups {
+			s.BackupBytes += b.size
+		}
+	}
+	if fw.async != nil {
+		s.Writes = fw.async.putCount()
+	}
+	s.Errors = atomic.LoadInt64(&fw.errorCount)
+	s.Dropped = fw.Dropped()
+	return s
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出 writers 运行统计的 http.Handler，
+// 每个 writer 以 file 标签区分，可以挂载到任意 http.ServeMux 上
+func MetricsHandler(writers ...*FileWriter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := make([]Stats, len(writers))
+		for i, fw := range writers {
+			stats[i] = fw.Stats()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w, stats...)
+	})
+}
+
+// metric 一个指标的名字、类型、说明和取值
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(s Stats) float64
+}
+
+var metrics = []metric{
+	{"logwriter_writes_total", "counter", "Number of writes to the log file.",
+		func(s Stats) float64 { return float64(s.Writes) }},
+	{"logwriter_written_bytes_total", "counter", "Bytes written to the log file.",
+		func(s Stats) float64 { return float64(s.BytesWritten) }},
+	{"logwriter_write_errors_total", "counter", "Number of failed writes.",
+		func(s Stats) float64 { return float64(s.WriteErrors) }},
+	{"logwriter_errors_total", "counter", "Number of errors reported to the error handler.",
+		func(s Stats) float64 { return float64(s.Errors) }},
+	{"logwriter_dropped_bytes_total", "counter", "Bytes dropped by fallback, disk guard or async overflow.",
+		func(s Stats) float64 { return float64(s.Dropped) }},
+	{"logwriter_write_latency_max_seconds", "gauge", "Maximum time spent in a single write.",
+		func(s Stats) float64 { return s.MaxWriteLatency.Seconds() }},
+	{"logwriter_last_write_timestamp_seconds", "gauge", "Unix time of the last write, 0 if none.",
+		func(s Stats) float64 { return unixSeconds(s.LastWrite) }},
+	{"logwriter_last_rotation_timestamp_seconds", "gauge", "Unix time of the last rotation, 0 if none.",
+		func(s Stats) float64 { return unixSeconds(s.LastRotation) }},
+	{"logwriter_file_bytes", "gauge", "Size of the live log file.",
+		func(s Stats) float64 { return float64(s.FileSize) }},
+	{"logwriter_backups", "gauge", "Number of rotated backups in the log directory.",
+		func(s Stats) float64 { return float64(s.Backups) }},
+	{"logwriter_backup_bytes", "gauge", "Total size of rotated backups.",
+		func(s Stats) float64 { return float64(s.BackupBytes) }},
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// WriteMetrics 以 Prometheus 文本格式输出运行统计，见 MetricsHandler
+func WriteMetrics(w io.Writer, stats ...Stats) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range stats {
+			fmt.Fprintf(bw, "%s{file=%s} %g\n", m.name, quoteLabel(s.File), m.value(s))
+		}
+	}
+
+	fmt.Fprint(bw, "# HELP logwriter_write_latency_seconds Time spent in writes, including rotations they trigger.\n# TYPE logwriter_write_latency_seconds summary\n")
+	for _, s := range stats {
+		fmt.Fprintf(bw, "logwriter_write_latency_seconds_sum{file=%s} %g\n", quoteLabel(s.File), s.WriteLatency.Seconds())
+		fmt.Fprintf(bw, "logwriter_write_latency_seconds_count{file=%s} %d\n", quoteLabel(s.File), s.Writes)
+	}
+
+	fmt.Fprint(bw, "# HELP logwriter_rotations_total Number of rotations by trigger.\n# TYPE logwriter_rotations_total counter\n")
+	for _, s := range stats {
+		triggers := make([]string, 0, len(s.Rotations))
+		for trigger := range s.Rotations {
+			triggers = append(triggers, trigger)
+		}
+		sort.Strings(triggers)
+		for _, trigger := range triggers {
+			fmt.Fprintf(bw, "logwriter_rotations_total{file=%s,trigger=%s} %d\n",
+				quoteLabel(s.File), quoteLabel(trigger), s.Rotations[trigger])
+		}
+	}
+	return bw.Flush()
+}
+
+// quoteLabel 按文本格式的规则转义标签值
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
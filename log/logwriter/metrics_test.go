@@ -0,0 +1,164 @@
+package log
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(10)
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	writer.SetFallback(FallbackDrop)
+
+	mustWrite(t, writer, "0123456789abc")
+	clock.Add(time.Minute)
+	mustWrite(t, writer, "de")
+	// 写入失败时计入错误数和丢弃字节数
+	fs.SetCapacity(15)
+	mustWrite(t, writer, "fghijk")
+	writer.reportError(errors.New("background"))
+
+	s := writer.Stats()
+	want := Stats{
+		File:         filepath.Join(defDir, defName),
+		Writes:       3,
+		BytesWritten: 15,
+		WriteErrors:  1,
+		Errors:       2,
+		Dropped:      6,
+		LastWrite:    clock.Now(),
+		Rotations:    map[string]int64{TriggerSize: 1},
+		LastRotation: clock.Now().Add(-time.Minute),
+		FileSize:     5,
+		Backups:      1,
+		BackupBytes:  10,
+	}
+	if s.WriteLatency <= 0 || s.MaxWriteLatency <= 0 || s.MaxWriteLatency > s.WriteLatency {
+		t.Errorf("got latency %v max %v", s.WriteLatency, s.MaxWriteLatency)
+	}
+	s.WriteLatency, s.MaxWriteLatency = 0, 0
+	if s.File != want.File || s.Writes != want.Writes || s.BytesWritten != want.BytesWritten ||
+		s.WriteErrors != want.WriteErrors || s.Errors != want.Errors || s.Dropped != want.Dropped ||
+		!s.LastWrite.Equal(want.LastWrite) || !s.LastRotation.Equal(want.LastRotation) ||
+		s.Rotations[TriggerSize] != 1 || len(s.Rotations) != 1 ||
+		s.FileSize != want.FileSize || s.Backups != want.Backups || s.BackupBytes != want.BackupBytes {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+	writer.Close()
+}
+
+func TestStatsAsync(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(100)
+	newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	writer.SetAsync(1024, OverflowBlock)
+	defer writer.Close()
+
+	// 异步写入按 Write 的次数统计，而不是后台批量写入的次数
+	for _, line := range []string{"a\n", "b\n", "c\n"} {
+		mustWrite(t, writer, line)
+	}
+	writer.Flush()
+	if s := writer.Stats(); s.Writes != 3 || s.BytesWritten != 6 {
+		t.Errorf("got writes %d bytes %d, want 3 and 6", s.Writes, s.BytesWritten)
+	}
+}
+
+func TestStatsDuringCompression(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(100)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	writer.SetCompressor(GzipCompressor)
+	defer writer.Close()
+
+	// 压缩文件已生成、源文件尚未删除时只统计压缩文件
+	fs.MkdirAll(defDir, 0777)
+	for name, data := range map[string]string{"2019-06-01.000.log": "0123456789", "2019-06-01.000.log.gz": "01234"} {
+		if err := writeFile(fs, filepath.Join(defDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := writer.Stats(); s.Backups != 1 || s.BackupBytes != 5 {
+		t.Errorf("got %d backups of %d bytes, want 1 of 5", s.Backups, s.BackupBytes)
+	}
+}
+
+// blockingDirFS ReadDir 在 release 关闭前阻塞，进入时通知 entered
+type blockingDirFS struct {
+	*MemFS
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (fs *blockingDirFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	fs.entered <- struct{}{}
+	<-fs.release
+	return fs.MemFS.ReadDir(dir)
+}
+
+func TestStatsDoesNotBlockWrite(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(1000)
+	mem, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	fs := &blockingDirFS{MemFS: mem, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	writer.SetFileSystem(fs)
+	defer writer.Close()
+	mustWrite(t, writer, "a\n")
+
+	done := make(chan Stats)
+	go func() { done <- writer.Stats() }()
+	<-fs.entered
+	// 统计备份文件时写入不被阻塞
+	written := make(chan struct{})
+	go func() {
+		mustWrite(t, writer, "b\n")
+		close(written)
+	}()
+	select {
+	case <-written:
+		close(fs.release)
+	case <-time.After(5 * time.Second):
+		close(fs.release)
+		t.Fatal("Write blocked by Stats")
+	}
+	if s := <-done; s.Writes < 1 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(4)
+	newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	other, _ := NewDateSplitWriter()
+	newMemWriter(other, time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC))
+	other.SetName(`a"b.log`)
+	mustWrite(t, writer, "01234567")
+	defer writer.Close()
+	defer other.Close()
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(writer, other).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got content type %q", ct)
+	}
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, line := range []string{
+		"# TYPE logwriter_written_bytes_total counter",
+		`logwriter_written_bytes_total{file="logs/default.log"} 8`,
+		`logwriter_written_bytes_total{file="logs/a\"b.log"} 0`,
+		`logwriter_backups{file="logs/default.log"} 2`,
+		`logwriter_last_write_timestamp_seconds{file="logs/default.log"} 1.5593832e+09`,
+		`logwriter_write_latency_seconds_count{file="logs/default.log"} 1`,
+		`logwriter_rotations_total{file="logs/default.log",trigger="size"} 2`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+	if n := strings.Count(string(body), "# TYPE logwriter_backups "); n != 1 {
+		t.Errorf("got %d TYPE lines for logwriter_backups, want 1", n)
+	}
+}
@@ -0,0 +1,292 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	defDestinationQueueSize = 1 << 20
+	defDestinationTimeout   = 100 * time.Millisecond
+)
+
+// Durability 目的地的写入方式
+type Durability int
+
+const (
+	// DurabilitySync 同步写入，Write 等待写入完成并返回该目的地的错误
+	DurabilitySync Durability = iota
+	// DurabilityAsync 放入该目的地的队列后由后台协程写入，队列已满时 Write 最多等待 Destination.Timeout，
+	// 超时丢弃本次数据
+	DurabilityAsync
+	// DurabilityBestEffort 放入该目的地的队列后由后台协程写入，队列已满时丢弃
+	DurabilityBestEffort
+)
+
+// Destination MultiWriter 的一个写入目的地
+type Destination struct {
+	// Name 目的地名字，用于统计和错误信息，为空时使用序号
+	Name string
+	// Writer 写入目标，可以是 FileWriter 或任意 io.Writer
+	Writer io.Writer
+	// Durability 写入方式，默认 DurabilitySync
+	Durability Durability
+	// QueueSize 异步写入时队列的容量 单位 B，默认 1MB
+	QueueSize int
+	// Timeout DurabilityAsync 队列已满时的最长等待时间，默认 100ms
+	Timeout time.Duration
+}
+
+// DestinationStats 一个目的地的写入统计
+type DestinationStats struct {
+	// Name 目的地名字
+	Name string
+	// Writes 写入次数
+	Writes int64
+	// Bytes 写入成功的字节数
+	Bytes int64
+	// Errors 写入失败的次数
+	Errors int64
+	// LastError 最近一次写入错误
+	LastError error
+	// Dropped 队列已满(异步写入时等待超时)而丢弃的字节数
+	Dropped int64
+	// TotalLatency 写入耗时之和，异步写入时不含排队时间
+	TotalLatency time.Duration
+	// MaxLatency 单次写入的最大耗时
+	MaxLatency time.Duration
+}
+
+// AvgLatency 返回单次写入的平均耗时
+func (s DestinationStats) AvgLatency() time.Duration {
+	if s.Writes == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Writes)
+}
+
+// MultiWriter 将同一份日志写入多个目的地，每个目的地可以选择同步、异步或尽力写入，
+// 各目的地互不阻塞：同步目的地并发写入，异步目的地由各自的协程写入
+type MultiWriter struct {
+	dests []*destination
+	// queued 按先尽力写入后异步写入排序的队列目的地，syncDests 同步目的地
+	queued    []*destination
+	syncDests []*destination
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// destination 一个目的地及其统计
+type destination struct {
+	name       string
+	writer     io.Writer
+	durability Durability
+	queue      *asyncQueue
+	timeout    time.Duration
+
+	writeMutex sync.Mutex
+	statsMutex sync.Mutex
+	stats      DestinationStats
+}
+
+// NewMultiWriter 返回写入 dests 的 MultiWriter
+func NewMultiWriter(dests ...Destination) (*MultiWriter, error) {
+	m := &MultiWriter{}
+	for i, d := range dests {
+		if d.Writer == nil {
+			return nil, fmt.Errorf("logwriter: destination %d has no writer", i)
+		}
+		name := d.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		dest := &destination{name: name, writer: d.Writer, durability: d.Durability}
+		dest.stats.Name = name
+		if d.Durability != DurabilitySync {
+			size := d.QueueSize
+			if size <= 0 {
+				size = defDestinationQueueSize
+			}
+			overflow := OverflowBlock
+			if d.Durability == DurabilityBestEffort {
+				overflow = OverflowDropNewest
+			}
+			dest.queue = newAsyncQueue(size, overflow)
+			dest.timeout = d.Timeout
+			if dest.timeout <= 0 {
+				dest.timeout = defDestinationTimeout
+			}
+		}
+		m.dests = append(m.dests, dest)
+	}
+	for _, durability := range []Durability{DurabilityBestEffort, DurabilityAsync, DurabilitySync} {
+		for _, dest := range m.dests {
+			switch {
+			case dest.durability != durability:
+			case dest.queue != nil:
+				m.queued = append(m.queued, dest)
+				go dest.loop()
+			default:
+				m.syncDests = append(m.syncDests, dest)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Write 实现 io.Writer 接口
+// 并发写入同步目的地的同时将数据放入尽力写入和异步写入目的地的队列，两者互不等待，
+// 异步目的地的队列已满时最多等待该目的地的 Timeout。
+// 返回第一个同步目的地的写入错误，异步写入的错误只计入统计
+func (m *MultiWriter) Write(p []byte) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	var err error
+	if len(m.queued) == 0 {
+		err = m.writeSync(p)
+	} else {
+		var done chan error
+		if len(m.syncDests) > 0 {
+			done = make(chan error, 1)
+			go func() { done <- m.writeSync(p) }()
+		}
+		for _, d := range m.queued {
+			d.queue.putTimeout(p, d.timeout)
+		}
+		if done != nil {
+			err = <-done
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeSync 并发写入同步目的地，返回第一个同步目的地的写入错误
+func (m *MultiWriter) writeSync(p []byte) error {
+	syncDests := m.syncDests
+	if len(syncDests) == 1 {
+		if err := syncDests[0].write(p); err != nil {
+			return fmt.Errorf("logwriter: destination %s: %v", syncDests[0].name, err)
+		}
+		return nil
+	}
+	errs := make([]error, len(syncDests))
+	var wg sync.WaitGroup
+	for i, d := range syncDests {
+		wg.Add(1)
+		go func(i int, d *destination) {
+			defer wg.Done()
+			errs[i] = d.write(p)
+		}(i, d)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("logwriter: destination %s: %v", syncDests[i].name, err)
+		}
+	}
+	return nil
+}
+
+// Flush 等待异步目的地队列中的数据全部写入，目的地实现了 Flush() error 时(如开启异步写入的 FileWriter)一并调用，
+// 返回第一个异步写入或 Flush 的错误
+func (m *MultiWriter) Flush() error {
+	var firstErr error
+	for _, d := range m.dests {
+		if d.queue != nil {
+			if err := d.queue.wait(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if f, ok := d.writer.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Close 写完异步目的地队列中的数据后停止后台协程，可重复调用。
+// 不关闭各目的地本身，FileWriter 等目的地需要由调用方关闭
+func (m *MultiWriter) Close() error {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mutex.Unlock()
+	for _, d := range m.dests {
+		if d.queue != nil {
+			d.queue.close()
+		}
+	}
+	return nil
+}
+
+// Stats 返回各目的地的写入统计，顺序与 NewMultiWriter 的参数一致
+func (m *MultiWriter) Stats() []DestinationStats {
+	stats := make([]DestinationStats, 0, len(m.dests))
+	for _, d := range m.dests {
+		d.statsMutex.Lock()
+		s := d.stats
+		d.statsMutex.Unlock()
+		if d.queue != nil {
+			s.Dropped = d.queue.droppedBytes()
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// write 写入目的地并记录统计，同一目的地的写入按顺序进行
+func (d *destination) write(p []byte) error {
+	d.writeMutex.Lock()
+	start := time.Now()
+	n, err := d.writer.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	latency := time.Since(start)
+	d.writeMutex.Unlock()
+
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+	d.stats.Writes++
+	d.stats.Bytes += int64(n)
+	d.stats.TotalLatency += latency
+	if latency > d.stats.MaxLatency {
+		d.stats.MaxLatency = latency
+	}
+	if err != nil {
+		d.stats.Errors++
+		d.stats.LastError = err
+	}
+	return err
+}
+
+// loop 依次写入队列中的数据
+func (d *destination) loop() {
+	defer close(d.queue.done)
+	for {
+		batch, ok := d.queue.take()
+		if !ok {
+			return
+		}
+		for _, p := range batch {
+			if err := d.write(p); err != nil {
+				d.queue.fail(fmt.Errorf("logwriter: destination %s: %v", d.name, err))
+			}
+		}
+		d.queue.release()
+	}
+}
@@ -0,0 +1,178 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriter 每次写入前等待 release 关闭
+type slowWriter struct {
+	mutex   sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *slowWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.String()
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestMultiWriter(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(1000)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	slow := &slowWriter{release: make(chan struct{})}
+	var mirror bytes.Buffer
+	m, err := NewMultiWriter(
+		Destination{Name: "file", Writer: writer},
+		Destination{Name: "mirror", Writer: &mirror},
+		Destination{Name: "remote", Writer: slow, Durability: DurabilityAsync},
+		Destination{Name: "debug", Writer: failWriter{}, Durability: DurabilityBestEffort, QueueSize: 8},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 异步目的地阻塞时不影响其他目的地
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			mustWrite(t, m, "line\n")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked by a slow async destination")
+	}
+	checkFiles(t, fs, defDir, map[string]string{defName: "line\nline\nline\n"})
+	if mirror.String() != "line\nline\nline\n" {
+		t.Errorf("mirror: got %q", mirror.String())
+	}
+
+	close(slow.release)
+	m.Flush()
+	if slow.String() != "line\nline\nline\n" {
+		t.Errorf("remote: got %q", slow.String())
+	}
+
+	stats := m.Stats()
+	if len(stats) != 4 {
+		t.Fatalf("got %d stats, want 4", len(stats))
+	}
+	if s := stats[0]; s.Name != "file" || s.Writes != 3 || s.Bytes != 15 || s.Errors != 0 {
+		t.Errorf("file: got %+v", s)
+	}
+	if s := stats[2]; s.Writes == 0 || s.Bytes != 15 || s.MaxLatency < s.AvgLatency() {
+		t.Errorf("remote: got %+v", s)
+	}
+	if s := stats[3]; s.Errors == 0 || s.LastError == nil || s.Errors+s.Dropped/5 != 3 {
+		t.Errorf("debug: got %+v", s)
+	}
+
+	m.Close()
+	if _, err := m.Write([]byte("x")); err != ErrClosed {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+	writer.Close()
+}
+
+func TestMultiWriterAsyncTimeout(t *testing.T) {
+	var mirror bytes.Buffer
+	slow := &slowWriter{release: make(chan struct{})}
+	m, _ := NewMultiWriter(
+		Destination{Name: "remote", Writer: slow, Durability: DurabilityAsync, QueueSize: 8, Timeout: 10 * time.Millisecond},
+		Destination{Name: "mirror", Writer: &mirror},
+	)
+
+	// 异步目的地的队列已满时等待超时后丢弃，同步目的地收到全部数据
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			mustWrite(t, m, "0123456\n")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked by a full async queue")
+	}
+	if mirror.Len() != 80 {
+		t.Errorf("mirror: got %d bytes, want 80", mirror.Len())
+	}
+
+	close(slow.release)
+	m.Close()
+	s := m.Stats()[0]
+	if s.Dropped == 0 || s.Bytes+s.Dropped != 80 {
+		t.Errorf("remote: got %+v, want the rest dropped", s)
+	}
+}
+
+func TestMultiWriterSyncHung(t *testing.T) {
+	hung := &slowWriter{release: make(chan struct{})}
+	remote := &slowWriter{release: make(chan struct{})}
+	close(remote.release)
+	m, _ := NewMultiWriter(
+		Destination{Name: "file", Writer: hung},
+		Destination{Name: "remote", Writer: remote, Durability: DurabilityAsync},
+	)
+
+	// 同步目的地阻塞时异步目的地照常收到数据
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mustWrite(t, m, "line\n")
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for remote.String() != "line\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("async destination blocked by a hung sync destination")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(hung.release)
+	<-done
+	m.Close()
+	if hung.String() != "line\n" {
+		t.Errorf("file: got %q", hung.String())
+	}
+}
+
+func TestMultiWriterSyncError(t *testing.T) {
+	var ok bytes.Buffer
+	m, _ := NewMultiWriter(
+		Destination{Name: "ok", Writer: &ok},
+		Destination{Name: "broken", Writer: failWriter{}},
+	)
+	defer m.Close()
+	_, err := m.Write([]byte("line\n"))
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("got %v, want error from broken", err)
+	}
+	if ok.String() != "line\n" {
+		t.Errorf("ok: got %q", ok.String())
+	}
+	if _, err := NewMultiWriter(Destination{Name: "nil"}); err == nil {
+		t.Error("NewMultiWriter accepted a destination without writer")
+	}
+}
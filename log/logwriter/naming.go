@@ -0,0 +1,291 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// NamedBackupTemplate 以日志名开头的备份文件命名模板，生成 <name>.<YYYY-MM-DD>.NNN<ext>，
+// 同一目录下不同名字的日志互不匹配对方的备份文件。
+// 未设置模板且日志文件名不是 default.log 时使用
+const NamedBackupTemplate = "{name}.{time:2006-01-02}.{seq}{ext}"
+
+// LegacyBackupTemplate 原来的备份文件命名模板，生成 <YYYY-MM-DD>.NNN.log，
+// 未设置模板且日志文件名为 default.log 时使用，与已有的备份文件保持一致
+const LegacyBackupTemplate = "{time:2006-01-02}.{seq}.log"
+
+// namedTemplate、legacyTemplate 默认模板，必然可以解析
+var (
+	namedTemplate, _  = ParseBackupTemplate(NamedBackupTemplate)
+	legacyTemplate, _ = ParseBackupTemplate(LegacyBackupTemplate)
+)
+
+// 模板占位符
+const (
+	phName = "name"
+	phExt  = "ext"
+	phTime = "time"
+	phSeq  = "seq"
+	phHost = "host"
+	phPID  = "pid"
+)
+
+// BackupTemplate 备份文件命名模板
+//
+// 支持的占位符：
+//
+//	{name}         日志文件名去掉扩展名，如 default.log 为 default
+//	{ext}          日志文件扩展名，如 .log
+//	{time:layout}  日志文件创建时间，layout 为 time.Format 格式，默认 2006-01-02
+//	{seq}          序号，同一时间内从 0 开始递增，{seq:N} 指定补零宽度，默认 3
+//	{host}         主机名
+//	{pid}          进程号
+//
+// 模板中必须包含 {seq}，以保证备份文件名不重复
+type BackupTemplate struct {
+	text  string
+	parts []templatePart
+	host  string
+}
+
+// templatePart 模板中的一段，placeholder 为空时表示字面量
+type templatePart struct {
+	literal     string
+	placeholder string
+	arg         string
+}
+
+// ParseBackupTemplate 解析备份文件命名模板
+func ParseBackupTemplate(text string) (*BackupTemplate, error) {
+	t := &BackupTemplate{text: text}
+	var hasSeq, hasTime bool
+	rest := text
+	for len(rest) > 0 {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:i]})
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("logwriter: unclosed placeholder in backup template %q", text)
+		}
+		field := rest[i+1 : i+j]
+		rest = rest[i+j+1:]
+
+		part := templatePart{placeholder: field}
+		if k := strings.IndexByte(field, ':'); k >= 0 {
+			part.placeholder, part.arg = field[:k], field[k+1:]
+		}
+		switch part.placeholder {
+		case phName, phExt, phPID:
+		case phHost:
+			host, err := os.Hostname()
+			if err != nil {
+				return nil, err
+			}
+			t.host = host
+		case phTime:
+			if hasTime {
+				return nil, fmt.Errorf("logwriter: duplicate {time} in backup template %q", text)
+			}
+			hasTime = true
+			if part.arg == "" {
+				part.arg = dateFormat
+			}
+		case phSeq:
+			if hasSeq {
+				return nil, fmt.Errorf("logwriter: duplicate {seq} in backup template %q", text)
+			}
+			hasSeq = true
+			if part.arg == "" {
+				part.arg = "3"
+			}
+			if w, err := strconv.Atoi(part.arg); err != nil || w < 0 {
+				return nil, fmt.Errorf("logwriter: invalid {seq} width in backup template %q", text)
+			}
+		default:
+			return nil, fmt.Errorf("logwriter: unknown placeholder {%s} in backup template %q", field, text)
+		}
+		t.parts = append(t.parts, part)
+	}
+	if !hasSeq {
+		return nil, errors.New("logwriter: backup template must contain {seq}")
+	}
+	if strings.ContainsRune(text, filepath.Separator) {
+		return nil, fmt.Errorf("logwriter: backup template %q must not contain path separator", text)
+	}
+	return t, nil
+}
+
+// String 返回模板原文
+func (t *BackupTemplate) String() string {
+	return t.text
+}
+
+// timeLayout 返回 {time} 的格式，模板中没有 {time} 时返回空字符串
+func (t *BackupTemplate) timeLayout() string {
+	for _, p := range t.parts {
+		if p.placeholder == phTime {
+			return p.arg
+		}
+	}
+	return ""
+}
+
+// SetBackupTemplate 设置备份文件命名模板，默认日志文件名为 default.log 时使用 LegacyBackupTemplate，否则使用 NamedBackupTemplate
+func (fw *FileWriter) SetBackupTemplate(text string) error {
+	t, err := ParseBackupTemplate(text)
+	if err != nil {
+		return err
+	}
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.backupTemplate = t
+	return nil
+}
+
+// template 返回备份文件命名模板，未设置时 default.log 使用 legacyTemplate，其余使用 namedTemplate
+func (fw *FileWriter) template() *BackupTemplate {
+	if fw.backupTemplate != nil {
+		return fw.backupTemplate
+	}
+	if fw.name == defName {
+		return legacyTemplate
+	}
+	return namedTemplate
+}
+
+// splitName 将日志文件名拆分为 {name} 和 {ext}
+func splitName(name string) (string, string) {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// format 按模板生成备份文件名
+func (t *BackupTemplate) format(name string, tm time.Time, seq int) string {
+	stem, ext := splitName(name)
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.placeholder {
+		case "":
+			b.WriteString(p.literal)
+		case phName:
+			b.WriteString(stem)
+		case phExt:
+			b.WriteString(ext)
+		case phTime:
+			b.WriteString(tm.Format(p.arg))
+		case phSeq:
+			width, _ := strconv.Atoi(p.arg)
+			fmt.Fprintf(&b, "%0*d", width, seq)
+		case phHost:
+			b.WriteString(t.host)
+		case phPID:
+			b.WriteString(strconv.Itoa(os.Getpid()))
+		}
+	}
+	return b.String()
+}
+
+// templateMatcher 用于从文件名中解析出模板各字段
+type templateMatcher struct {
+	re         *regexp.Regexp
+	timeLayout string
+	location   *time.Location
+}
+
+// matcher 生成匹配日志文件 name 的备份文件名的正则，
+// 备份文件名之后可以带一个压缩后缀，如 .gz。时间字段按生成文件名时使用的时区 loc 解析
+func (t *BackupTemplate) matcher(name string, loc *time.Location) *templateMatcher {
+	stem, ext := splitName(name)
+	m := &templateMatcher{location: loc}
+	var b strings.Builder
+	b.WriteString("^")
+	for _, p := range t.parts {
+		switch p.placeholder {
+		case "":
+			b.WriteString(regexp.QuoteMeta(p.literal))
+		case phName:
+			b.WriteString(regexp.QuoteMeta(stem))
+		case phExt:
+			b.WriteString(regexp.QuoteMeta(ext))
+		case phTime:
+			m.timeLayout = p.arg
+			b.WriteString("(?P<time>" + layoutPattern(p.arg) + ")")
+		case phSeq:
+			b.WriteString(`(?P<seq>\d+)`)
+		case phHost:
+			b.WriteString(`[A-Za-z0-9._-]+?`)
+		case phPID:
+			b.WriteString(`\d+`)
+		}
+	}
+	b.WriteString(`(?P<ext>\.[A-Za-z0-9]+)?$`)
+	m.re = regexp.MustCompile(b.String())
+	return m
+}
+
+// layoutPattern 将 time.Format 格式转换为宽松的正则，匹配结果再用 time.Parse 校验
+func layoutPattern(layout string) string {
+	var b strings.Builder
+	runes := []rune(layout)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case unicode.IsDigit(r):
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			b.WriteString(`\d+`)
+		case unicode.IsLetter(r):
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			b.WriteString(`[A-Za-z]+`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// match 解析备份文件名，返回时间字段原文、解析后的时间、序号和压缩后缀
+func (m *templateMatcher) match(fileName string) (key string, tm time.Time, seq int, ext string, ok bool) {
+	sub := m.re.FindStringSubmatch(fileName)
+	if sub == nil {
+		return "", time.Time{}, 0, "", false
+	}
+	for i, group := range m.re.SubexpNames() {
+		switch group {
+		case "time":
+			key = sub[i]
+			t, err := time.ParseInLocation(m.timeLayout, key, m.location)
+			if err != nil {
+				return "", time.Time{}, 0, "", false
+			}
+			tm = t
+		case "seq":
+			n, err := strconv.Atoi(sub[i])
+			if err != nil {
+				return "", time.Time{}, 0, "", false
+			}
+			seq = n
+		case "ext":
+			ext = sub[i]
+		}
+	}
+	return key, tm, seq, ext, true
+}
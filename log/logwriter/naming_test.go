@@ -0,0 +1,93 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseBackupTemplate(t *testing.T) {
+	for _, text := range []string{
+		"{time}.log",
+		"{name}-{seq",
+		"{name}-{seq}-{seq}",
+		"{name}-{bad}-{seq}",
+		"{name}-{seq:x}",
+		"sub/{name}-{seq}",
+	} {
+		if _, err := ParseBackupTemplate(text); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+
+	tmpl, err := ParseBackupTemplate("{name}-{time:20060102T1504}-{pid}.{seq:4}{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := time.Date(2019, 6, 1, 13, 45, 0, 0, time.Local)
+	name := tmpl.format("app.log", tm, 7)
+	want := "app-20190601T1345-" + strconv.Itoa(os.Getpid()) + ".0007.log"
+	if name != want {
+		t.Fatalf("format: got %q, want %q", name, want)
+	}
+
+	m := tmpl.matcher("app.log", time.Local)
+	key, stamp, seq, ext, ok := m.match(name + ".gz")
+	if !ok || key != "20190601T1345" || !stamp.Equal(tm) || seq != 7 || ext != ".gz" {
+		t.Errorf("match: got (%q, %v, %d, %q, %v)", key, stamp, seq, ext, ok)
+	}
+	for _, other := range []string{
+		"other-20190601T1345-1.0007.log",
+		"app-20191301T1345-1.0007.log",
+		"app.log",
+	} {
+		if _, _, _, _, ok := m.match(other); ok {
+			t.Errorf("match %q: expected no match", other)
+		}
+	}
+}
+
+func TestBackupTemplateSharedDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var writers []*FileWriter
+	for _, name := range []string{"a.log", "b.log"} {
+		w, _ := NewDateSplitWriter()
+		w.SetDir(dir)
+		w.SetName(name)
+		if err := w.SetBackupTemplate("{name}.{time:2006-01-02}.{seq}{ext}"); err != nil {
+			t.Fatal(err)
+		}
+		writers = append(writers, w)
+	}
+	for i := 0; i < 2; i++ {
+		for _, w := range writers {
+			w.Write([]byte(w.name))
+			w.fileMutex.Lock()
+			w.rotate(TriggerManual)
+			w.fileMutex.Unlock()
+		}
+	}
+
+	date := time.Now().Format(dateFormat)
+	names := listNames(t, dir)
+	for _, name := range []string{"a", "b"} {
+		for seq := 0; seq < 2; seq++ {
+			backup := name + "." + date + ".00" + strconv.Itoa(seq) + ".log"
+			if !names[backup] {
+				t.Errorf("missing backup %s in %v", backup, names)
+			}
+			data, _ := ioutil.ReadFile(filepath.Join(dir, backup))
+			if string(data) != name+".log" {
+				t.Errorf("%s: got %q", backup, data)
+			}
+		}
+	}
+}
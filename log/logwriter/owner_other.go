@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package log
+
+import (
+	"os"
+)
+
+// sysOwner 当前平台不支持读取属主
+func sysOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package log
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysOwner 从 stat 结果中读取属主和属组
+func sysOwner(info os.FileInfo) (int, int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
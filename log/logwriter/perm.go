@@ -0,0 +1,138 @@
+package log
+
+import (
+	"errors"
+	"os"
+)
+
+// PermFS 由可以修改权限和属主的文件系统实现，OSFileSystem 和 MemFS 都实现了该接口
+type PermFS interface {
+	// Chmod 见 os.Chmod
+	Chmod(name string, mode os.FileMode) error
+	// Chown 见 os.Chown，uid 或 gid 为 -1 时不修改
+	Chown(name string, uid, gid int) error
+}
+
+// Chmod 见 os.Chmod
+func (OSFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chown 见 os.Chown
+func (OSFileSystem) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+// Permissions 日志目录和日志文件的权限与属主
+type Permissions struct {
+	// DirMode 日志目录的权限，为 0 时以 0777 创建(受 umask 影响)，否则创建后修改为 DirMode，不受 umask 影响
+	DirMode os.FileMode
+	// FileMode 日志文件和状态文件的权限，为 0 时以 0666 创建(受 umask 影响)，否则创建后修改为 FileMode，不受 umask 影响
+	FileMode os.FileMode
+	// UID 日志目录和日志文件的属主，为 nil 时不修改，通常需要以 root 运行
+	UID *int
+	// GID 日志目录和日志文件的属组，为 nil 时不修改
+	GID *int
+	// ReadOnlyBackups 备份后去掉备份文件的写权限，压缩后的备份文件同样只读
+	ReadOnlyBackups bool
+}
+
+// errNoPermFS 设置了权限或属主但文件系统不支持修改时返回
+var errNoPermFS = errors.New("logwriter: file system does not support chmod and chown")
+
+// SetPermissions 设置日志目录和日志文件的权限与属主，对之后创建的目录和文件生效
+func (fw *FileWriter) SetPermissions(p Permissions) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.perm = p
+}
+
+// dirPerm 返回创建日志目录时使用的权限，调用方需持有 fileMutex
+func (fw *FileWriter) dirPerm() os.FileMode {
+	if fw.perm.DirMode == 0 {
+		return 0777
+	}
+	return fw.perm.DirMode
+}
+
+// filePerm 返回创建日志文件时使用的权限，调用方需持有 fileMutex
+func (fw *FileWriter) filePerm() os.FileMode {
+	if fw.perm.FileMode == 0 {
+		return 0666
+	}
+	return fw.perm.FileMode
+}
+
+// applyPerm 将新创建的目录或文件修改为 mode (为 0 时不修改)并设置属主，调用方需持有 fileMutex
+func (fw *FileWriter) applyPerm(path string, mode os.FileMode) error {
+	if mode == 0 && fw.perm.UID == nil && fw.perm.GID == nil {
+		return nil
+	}
+	pfs, ok := fw.filesystem().(PermFS)
+	if !ok {
+		return errNoPermFS
+	}
+	if mode != 0 {
+		if err := pfs.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if fw.perm.UID == nil && fw.perm.GID == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if fw.perm.UID != nil {
+		uid = *fw.perm.UID
+	}
+	if fw.perm.GID != nil {
+		gid = *fw.perm.GID
+	}
+	return pfs.Chown(path, uid, gid)
+}
+
+// protectBackup 去掉备份文件的写权限，调用方需持有 fileMutex
+func (fw *FileWriter) protectBackup(path string) error {
+	if !fw.perm.ReadOnlyBackups {
+		return nil
+	}
+	pfs, ok := fw.filesystem().(PermFS)
+	if !ok {
+		return errNoPermFS
+	}
+	info, err := fw.filesystem().Stat(path)
+	if err != nil {
+		return err
+	}
+	return pfs.Chmod(path, info.Mode().Perm()&^0222)
+}
+
+// copyPerm 将 src 的权限和属主复制到 dst，用于压缩后的备份文件，文件系统不支持时忽略
+func copyPerm(fs FileSystem, src os.FileInfo, dst string) error {
+	pfs, ok := fs.(PermFS)
+	if !ok {
+		return nil
+	}
+	if err := pfs.Chmod(dst, src.Mode().Perm()); err != nil {
+		return err
+	}
+	uid, gid, ok := fileOwner(src)
+	if !ok {
+		return nil
+	}
+	info, err := fs.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if duid, dgid, ok := fileOwner(info); ok && duid == uid && dgid == gid {
+		return nil
+	}
+	return pfs.Chown(dst, uid, gid)
+}
+
+// fileOwner 返回文件的属主和属组，无法获取时 ok 为 false
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	if e, isMem := info.Sys().(*memEntry); isMem {
+		return e.uid, e.gid, true
+	}
+	return sysOwner(info)
+}
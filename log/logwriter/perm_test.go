@@ -0,0 +1,103 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// chownFS 记录 Chown 调用的内存文件系统
+type chownFS struct {
+	*MemFS
+	owners map[string][2]int
+}
+
+func (fs *chownFS) Chown(name string, uid, gid int) error {
+	fs.owners[name] = [2]int{uid, gid}
+	return fs.MemFS.Chown(name, uid, gid)
+}
+
+func TestPermissions(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(4)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	writer.SetCompressor(GzipCompressor)
+	writer.SetPermissions(Permissions{DirMode: 0750, FileMode: 0640, UID: intPtr(1000), GID: intPtr(2000), ReadOnlyBackups: true})
+
+	mustWrite(t, writer, "0123")
+	mustWrite(t, writer, "4567")
+	writer.Close()
+
+	for path, want := range map[string]os.FileMode{
+		defDir:                         os.ModeDir | 0750,
+		filepath.Join(defDir, defName): 0640,
+		writer.stateName():             0640,
+		filepath.Join(defDir, "2019-06-01.000.log.gz"): 0440,
+	} {
+		info, err := fs.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s: got mode %v, want %v", path, info.Mode(), want)
+		}
+		if uid, gid, _ := fileOwner(info); uid != 1000 || gid != 2000 {
+			t.Errorf("%s: got owner %d:%d, want 1000:2000", path, uid, gid)
+		}
+	}
+	if _, err := fs.OpenFile(filepath.Join(defDir, "2019-06-01.000.log.gz"), os.O_WRONLY, 0); !os.IsPermission(err) {
+		t.Errorf("open read-only backup for writing: got %v, want permission error", err)
+	}
+}
+
+func TestPermissionsRootOwner(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(4)
+	mem, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs := &chownFS{MemFS: mem, owners: make(map[string][2]int)}
+	writer.SetFileSystem(fs)
+	// 属主可以设置为 root，未设置的属组不修改
+	writer.SetPermissions(Permissions{UID: intPtr(0)})
+	mustWrite(t, writer, "0123")
+	writer.Close()
+
+	for _, path := range []string{defDir, filepath.Join(defDir, defName)} {
+		if got := fs.owners[path]; got != [2]int{0, -1} {
+			t.Errorf("%s: got chown %v, want [0 -1]", path, got)
+		}
+	}
+}
+
+func TestPermissionsIgnoreUmask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewSizeSplitWriter(4)
+	writer.SetDir(filepath.Join(dir, "logs"))
+	writer.SetClock(newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local)))
+	writer.SetPermissions(Permissions{DirMode: 0777, FileMode: 0666, ReadOnlyBackups: true})
+	mustWrite(t, writer, "0123")
+	mustWrite(t, writer, "4567")
+	writer.Close()
+
+	for name, want := range map[string]os.FileMode{
+		"logs":                    os.ModeDir | 0777,
+		"logs/" + defName:         0666,
+		"logs/2019-06-01.000.log": 0444,
+	} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s: got mode %v, want %v", name, info.Mode(), want)
+		}
+	}
+}
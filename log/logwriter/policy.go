@@ -0,0 +1,301 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置切分条件的名字，切分时记录触发的条件
+const (
+	TriggerSize     = "size"
+	TriggerInterval = "interval"
+	TriggerDaily    = "daily"
+	TriggerHourly   = "hourly"
+	TriggerCron     = "cron"
+	TriggerManual   = "manual"
+)
+
+// FileState 当前日志文件的状态，用于判断是否需要切分
+type FileState struct {
+	// CreateTime 文件创建时间
+	CreateTime time.Time
+	// Size 文件大小 单位 B
+	Size int64
+}
+
+// SplitPolicy 日志切分条件
+type SplitPolicy interface {
+	// ShouldSplit 判断当前文件是否需要切分，需要切分时返回触发的条件名
+	ShouldSplit(st FileState, now time.Time) (trigger string, ok bool)
+}
+
+// SetSplitPolicy 设置切分条件，替换构造时指定的切分方式
+func (fw *FileWriter) SetSplitPolicy(p SplitPolicy) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.splitType = STypePolicy
+	fw.policy = p
+}
+
+// LastTrigger 返回最近一次切分触发的条件名，尚未切分时为空
+func (fw *FileWriter) LastTrigger() string {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	return fw.lastTrigger
+}
+
+// Rotations 返回按触发条件统计的切分次数
+func (fw *FileWriter) Rotations() map[string]int64 {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	rotations := make(map[string]int64, len(fw.rotations))
+	for trigger, n := range fw.rotations {
+		rotations[trigger] = n
+	}
+	return rotations
+}
+
+// sizeLimiter 由包含大小条件的切分条件实现，Write 据此保证文件恰好在上限处切分
+type sizeLimiter interface {
+	sizeLimit() int64
+}
+
+// SizePolicy 文件大小达到 size 字节时切分
+func SizePolicy(size int64) SplitPolicy {
+	return sizePolicy(size)
+}
+
+type sizePolicy int64
+
+func (p sizePolicy) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	return TriggerSize, p > 0 && st.Size >= int64(p)
+}
+
+func (p sizePolicy) sizeLimit() int64 {
+	return int64(p)
+}
+
+// IntervalPolicy 文件创建 d 时间后切分
+func IntervalPolicy(d time.Duration) SplitPolicy {
+	return intervalPolicy(d)
+}
+
+type intervalPolicy time.Duration
+
+func (p intervalPolicy) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	return TriggerInterval, p > 0 && !now.Before(st.CreateTime.Add(time.Duration(p)))
+}
+
+// DailyPolicy 跨越自然日时切分，自然日按时钟的时区计算
+func DailyPolicy() SplitPolicy {
+	return dailyPolicy{}
+}
+
+type dailyPolicy struct{}
+
+func (dailyPolicy) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	loc := now.Location()
+	cy, cm, cd := st.CreateTime.In(loc).Date()
+	ny, nm, nd := now.Date()
+	return TriggerDaily, time.Date(ny, nm, nd, 0, 0, 0, 0, loc).
+		After(time.Date(cy, cm, cd, 0, 0, 0, 0, loc))
+}
+
+// HourlyPolicy 跨越整点时切分，整点按时钟的时区计算
+func HourlyPolicy() SplitPolicy {
+	return hourlyPolicy{}
+}
+
+type hourlyPolicy struct{}
+
+func (hourlyPolicy) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	loc := now.Location()
+	create := st.CreateTime.In(loc)
+	cy, cm, cd := create.Date()
+	ny, nm, nd := now.Date()
+	return TriggerHourly, time.Date(ny, nm, nd, now.Hour(), 0, 0, 0, loc).
+		After(time.Date(cy, cm, cd, create.Hour(), 0, 0, 0, loc))
+}
+
+// AnyOf 任一条件满足时切分，触发的条件名为第一个满足的条件
+func AnyOf(policies ...SplitPolicy) SplitPolicy {
+	return anyOf(policies)
+}
+
+type anyOf []SplitPolicy
+
+func (ps anyOf) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	for _, p := range ps {
+		if trigger, ok := p.ShouldSplit(st, now); ok {
+			return trigger, true
+		}
+	}
+	return "", false
+}
+
+// sizeLimit 取各子条件中最小的大小上限
+func (ps anyOf) sizeLimit() int64 {
+	var limit int64
+	for _, p := range ps {
+		if l, ok := p.(sizeLimiter); ok {
+			if n := l.sizeLimit(); n > 0 && (limit == 0 || n < limit) {
+				limit = n
+			}
+		}
+	}
+	return limit
+}
+
+// AllOf 所有条件同时满足时切分，触发的条件名以 "+" 连接
+func AllOf(policies ...SplitPolicy) SplitPolicy {
+	return allOf(policies)
+}
+
+type allOf []SplitPolicy
+
+func (ps allOf) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	if len(ps) == 0 {
+		return "", false
+	}
+	triggers := make([]string, 0, len(ps))
+	for _, p := range ps {
+		trigger, ok := p.ShouldSplit(st, now)
+		if !ok {
+			return "", false
+		}
+		triggers = append(triggers, trigger)
+	}
+	return strings.Join(triggers, "+"), true
+}
+
+// CronPolicy 按照 cron 表达式切分，创建文件后经过一个调度时间点即切分
+//
+// 表达式为 "分 时 日 月 周" 五个字段，支持 *、数字、列表 a,b、范围 a-b 和步长 */n、a-b/n，
+// 周的取值为 0-6 (0 或 7 为周日)，日和周同时指定时满足其一即可
+func CronPolicy(spec string) (SplitPolicy, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("logwriter: cron spec %q must have 5 fields", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	p := &cronPolicy{spec: spec}
+	sets := []*uint64{&p.minute, &p.hour, &p.dom, &p.month, &p.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("logwriter: cron spec %q: %v", spec, err)
+		}
+		*sets[i] = set
+	}
+	if p.dow&(1<<7) != 0 {
+		p.dow |= 1
+	}
+	// 以 * 开头或取遍所有值的字段视为不限制，与另一个字段同时满足才匹配
+	p.domStar = strings.HasPrefix(fields[2], "*") || p.dom == cronRange(1, 31)
+	p.dowStar = strings.HasPrefix(fields[4], "*") || p.dow&cronRange(0, 6) == cronRange(0, 6)
+	return p, nil
+}
+
+// cronRange 返回 min 到 max 所有取值的位图
+func cronRange(min, max int) uint64 {
+	var set uint64
+	for v := min; v <= max; v++ {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+type cronPolicy struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	mutex                         sync.Mutex
+	from, next                    time.Time
+}
+
+func (p *cronPolicy) ShouldSplit(st FileState, now time.Time) (string, bool) {
+	p.mutex.Lock()
+	if !p.from.Equal(st.CreateTime) {
+		p.from = st.CreateTime
+		p.next = p.nextAfter(st.CreateTime)
+	}
+	next := p.next
+	p.mutex.Unlock()
+	return TriggerCron, !next.IsZero() && !now.Before(next)
+}
+
+// nextAfter 返回 t 之后的第一个调度时间点，五年内没有时返回零值
+func (p *cronPolicy) nextAfter(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if p.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !p.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if p.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if p.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (p *cronPolicy) dayMatch(t time.Time) bool {
+	domMatch := p.dom&(1<<uint(t.Day())) != 0
+	dowMatch := p.dow&(1<<uint(t.Weekday())) != 0
+	if p.domStar || p.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// parseCronField 解析 cron 表达式的一个字段，返回取值集合的位图
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			rng, step = item[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			parts := strings.SplitN(rng, "-", 2)
+			n, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", item)
+			}
+			lo, hi = n, n
+			if len(parts) == 2 {
+				if hi, err = strconv.Atoi(parts[1]); err != nil {
+					return 0, fmt.Errorf("invalid range in %q", item)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range in %q", item)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
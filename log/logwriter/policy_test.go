@@ -0,0 +1,105 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronPolicy(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := CronPolicy(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+
+	base := time.Date(2019, 6, 1, 13, 45, 30, 0, time.Local) // 周六
+	for spec, want := range map[string]time.Time{
+		"* * * * *":  time.Date(2019, 6, 1, 13, 46, 0, 0, time.Local),
+		"0 * * * *":  time.Date(2019, 6, 1, 14, 0, 0, 0, time.Local),
+		"30 2 * * *": time.Date(2019, 6, 2, 2, 30, 0, 0, time.Local),
+		"0 0 * * 1":  time.Date(2019, 6, 3, 0, 0, 0, 0, time.Local),
+		"0 0 1 * *":  time.Date(2019, 7, 1, 0, 0, 0, 0, time.Local),
+		"0 0 15 * 1": time.Date(2019, 6, 3, 0, 0, 0, 0, time.Local),
+		"0 0 29 2 *": time.Date(2020, 2, 29, 0, 0, 0, 0, time.Local),
+		// 以 * 开头或取遍所有值的日、周字段不限制
+		"0 0 */1 * 1":  time.Date(2019, 6, 3, 0, 0, 0, 0, time.Local),
+		"0 0 1-31 * 1": time.Date(2019, 6, 3, 0, 0, 0, 0, time.Local),
+		"0 0 15 * 0-6": time.Date(2019, 6, 15, 0, 0, 0, 0, time.Local),
+		"0 0 15 * */2": time.Date(2019, 6, 15, 0, 0, 0, 0, time.Local),
+	} {
+		p, err := CronPolicy(spec)
+		if err != nil {
+			t.Fatalf("%q: %v", spec, err)
+		}
+		st := FileState{CreateTime: base}
+		if _, ok := p.ShouldSplit(st, want.Add(-time.Second)); ok {
+			t.Errorf("%q: split before %v", spec, want)
+		}
+		if trigger, ok := p.ShouldSplit(st, want); !ok || trigger != TriggerCron {
+			t.Errorf("%q: no split at %v", spec, want)
+		}
+	}
+}
+
+func TestPolicyLocation(t *testing.T) {
+	// 按时钟的时区判断自然日和整点，与 time.Local 无关
+	loc := time.FixedZone("UTC+8:30", 8*3600+1800)
+	base := time.Date(2019, 6, 1, 23, 50, 0, 0, loc)
+	st := FileState{CreateTime: base.In(time.UTC)}
+	for _, p := range []SplitPolicy{DailyPolicy(), HourlyPolicy()} {
+		if _, ok := p.ShouldSplit(st, base.Add(5*time.Minute)); ok {
+			t.Errorf("%T: unexpected split", p)
+		}
+		if _, ok := p.ShouldSplit(st, base.Add(10*time.Minute)); !ok {
+			t.Errorf("%T: no split at midnight", p)
+		}
+	}
+}
+
+func TestCombinedPolicy(t *testing.T) {
+	base := time.Date(2019, 6, 1, 23, 0, 0, 0, time.Local)
+	next := base.Add(2 * time.Hour)
+
+	p := AnyOf(DailyPolicy(), SizePolicy(100))
+	if trigger, ok := p.ShouldSplit(FileState{CreateTime: base, Size: 100}, base); !ok || trigger != TriggerSize {
+		t.Errorf("AnyOf size: got (%q, %v)", trigger, ok)
+	}
+	if trigger, ok := p.ShouldSplit(FileState{CreateTime: base, Size: 10}, next); !ok || trigger != TriggerDaily {
+		t.Errorf("AnyOf daily: got (%q, %v)", trigger, ok)
+	}
+	if _, ok := p.ShouldSplit(FileState{CreateTime: base, Size: 10}, base.Add(time.Minute)); ok {
+		t.Error("AnyOf: unexpected split")
+	}
+
+	p = AllOf(HourlyPolicy(), SizePolicy(100))
+	if _, ok := p.ShouldSplit(FileState{CreateTime: base, Size: 100}, base); ok {
+		t.Error("AllOf: unexpected split")
+	}
+	if trigger, ok := p.ShouldSplit(FileState{CreateTime: base, Size: 100}, next); !ok || trigger != "hourly+size" {
+		t.Errorf("AllOf: got (%q, %v)", trigger, ok)
+	}
+}
+
+func TestPolicySplitWriter(t *testing.T) {
+	writer, _ := NewPolicySplitWriter(AnyOf(IntervalPolicy(time.Minute), SizePolicy(10)))
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "0123456789abc")
+	if trigger := writer.LastTrigger(); trigger != TriggerSize {
+		t.Errorf("LastTrigger: got %q, want %q", trigger, TriggerSize)
+	}
+	clock.Add(time.Minute)
+	mustWrite(t, writer, "d")
+	if trigger := writer.LastTrigger(); trigger != TriggerInterval {
+		t.Errorf("LastTrigger: got %q, want %q", trigger, TriggerInterval)
+	}
+	rotations := writer.Rotations()
+	if rotations[TriggerSize] != 1 || rotations[TriggerInterval] != 1 {
+		t.Errorf("Rotations: got %v", rotations)
+	}
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "0123456789",
+		"2019-06-01.001.log": "abc",
+		defName:              "d",
+	})
+}
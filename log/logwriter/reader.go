@@ -0,0 +1,291 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Segment 一段日志：一个备份文件或当前日志文件
+type Segment struct {
+	// Path 文件路径
+	Path string
+	// Start 最早一条日志的时间下界：备份文件为文件名中的时间(精度由命名模板决定)，当前日志文件为创建时间
+	Start time.Time
+	// End 最后一条日志的时间上界：备份文件为修改时间，当前日志文件为列出时的时间
+	End time.Time
+	// Size 文件大小，压缩文件为压缩后的大小
+	Size int64
+	// Compressed 是否为压缩文件
+	Compressed bool
+	// Live 是否为当前日志文件
+	Live bool
+}
+
+// ReadOptions 读取日志的选项
+type ReadOptions struct {
+	// From 只读取 From 之后(含)的日志，为零值时不限制
+	From time.Time
+	// To 只读取 To 之前(不含)的日志，为零值时不限制
+	To time.Time
+	// Timestamp 从一行日志中解析时间，为 nil 时只按 Segment 的时间范围筛选文件，
+	// 设置后逐行筛选，无法解析时间的行(如多行日志的后续行)跟随上一行
+	Timestamp func(line []byte) (time.Time, bool)
+}
+
+// overlaps 判断 [start, end] 是否与 [From, To) 有交集
+func (o ReadOptions) overlaps(start, end time.Time) bool {
+	if !o.From.IsZero() && end.Before(o.From) {
+		return false
+	}
+	if !o.To.IsZero() && !start.Before(o.To) {
+		return false
+	}
+	return true
+}
+
+// contains 判断 t 是否在 [From, To) 内
+func (o ReadOptions) contains(t time.Time) bool {
+	return (o.From.IsZero() || !t.Before(o.From)) && (o.To.IsZero() || t.Before(o.To))
+}
+
+// Segments 按从旧到新的顺序列出日志目录中符合备份命名模板的备份文件和当前日志文件。
+// 不需要写入过日志，只设置了目录、文件名和命名模板的 FileWriter 也可以用来列出已有的日志
+func (fw *FileWriter) Segments() ([]Segment, error) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	segments, _, err := fw.segments()
+	return segments, err
+}
+
+// segments 列出日志文件，返回当前日志文件的大小，当前日志文件不存在时为 -1，调用方需持有 fileMutex
+func (fw *FileWriter) segments() ([]Segment, int64, error) {
+	backups, err := fw.listBackups()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, -1, err
+	}
+	listed := make(map[string]bool, len(backups))
+	for _, b := range backups {
+		listed[b.path] = true
+	}
+	segments := make([]Segment, 0, len(backups)+1)
+	for _, b := range backups {
+		// 压缩完成、尚未删除源文件时两者同时存在，只保留源文件
+		if b.ext != "" && listed[strings.TrimSuffix(b.path, b.ext)] {
+			continue
+		}
+		segments = append(segments, Segment{
+			Path:       b.path,
+			Start:      b.stamp,
+			End:        b.modTime,
+			Size:       b.size,
+			Compressed: b.ext != "",
+		})
+	}
+	live := fw.livePath()
+	info, err := fw.filesystem().Stat(live)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return segments, -1, nil
+		}
+		return nil, -1, err
+	}
+	start := fw.createTime
+	if fw.file == nil {
+		if t, _, err := fw.readState(); err == nil {
+			start = t
+		} else {
+			start = info.ModTime()
+		}
+	}
+	segments = append(segments, Segment{
+		Path:  live,
+		Start: start,
+		End:   fw.now(),
+		Size:  info.Size(),
+		Live:  true,
+	})
+	return segments, info.Size(), nil
+}
+
+// livePath 返回当前日志文件的路径，调用方需持有 fileMutex
+func (fw *FileWriter) livePath() string {
+	if fw.file != nil {
+		return fw.fileName
+	}
+	return filepath.Join(fw.dir, fw.name)
+}
+
+// NewReader 返回按从旧到新的顺序依次读取备份文件和当前日志文件的 reader，压缩的备份文件自动解压，
+// 只读取与 [From, To) 有交集的文件，设置了 Timestamp 时再逐行筛选。
+// 当前日志文件只读取到调用时已写入的位置；读取过程中被压缩的备份文件改为读取压缩后的文件，已被清理的跳过。
+// 文件头、文件尾和防篡改模式的标记行原样返回
+func (fw *FileWriter) NewReader(opts ReadOptions) (io.ReadCloser, error) {
+	fw.fileMutex.Lock()
+	segments, size, err := fw.segments()
+	if err != nil {
+		fw.fileMutex.Unlock()
+		return nil, err
+	}
+	r := &segmentReader{fs: fw.filesystem(), compressor: fw.compressorOf()}
+	for _, s := range segments {
+		if opts.overlaps(s.Start, s.End) {
+			r.segments = append(r.segments, s)
+		}
+	}
+	// 当前日志文件在持有锁时打开，之后即使被切分，读到的仍是调用时的文件
+	if n := len(r.segments); n > 0 && r.segments[n-1].Live {
+		f, err := r.fs.OpenFile(r.segments[n-1].Path, os.O_RDONLY, 0)
+		if err != nil {
+			fw.fileMutex.Unlock()
+			return nil, err
+		}
+		r.live = &limitedFile{Reader: io.LimitReader(f, size), file: f}
+	}
+	fw.fileMutex.Unlock()
+
+	if opts.Timestamp == nil || (opts.From.IsZero() && opts.To.IsZero()) {
+		return r, nil
+	}
+	return &lineFilter{src: r, r: bufio.NewReader(r), opts: opts}, nil
+}
+
+// NewDirReader 读取 dir 中使用默认文件名和备份命名模板的日志，见 FileWriter.NewReader。
+// 其他文件名或命名模板可以用 NewConfigWriter 创建 FileWriter 后调用 NewReader
+func NewDirReader(dir string, opts ReadOptions) (io.ReadCloser, error) {
+	fw, _ := NewDateSplitWriter()
+	fw.SetDir(dir)
+	return fw.NewReader(opts)
+}
+
+// segmentReader 依次读取多个日志文件
+type segmentReader struct {
+	fs         FileSystem
+	compressor *Compressor
+	segments   []Segment
+	live       io.ReadCloser
+	cur        io.ReadCloser
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.segments) == 0 {
+				return 0, io.EOF
+			}
+			cur, err := r.open(r.segments[0])
+			if err != nil {
+				return 0, err
+			}
+			r.segments = r.segments[1:]
+			if cur == nil {
+				continue
+			}
+			r.cur = cur
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			err = r.cur.Close()
+			r.cur = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// open 打开一个日志文件，备份文件已被压缩时打开压缩后的文件，已被清理时返回 nil
+func (r *segmentReader) open(s Segment) (io.ReadCloser, error) {
+	if s.Live {
+		live := r.live
+		r.live = nil
+		return live, nil
+	}
+	b := backupFile{path: s.Path}
+	if s.Compressed {
+		b.ext = filepath.Ext(s.Path)
+	}
+	rc, err := openBackup(r.fs, r.compressor, b)
+	if err == nil || !os.IsNotExist(err) || s.Compressed {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return rc, err
+	}
+	b.ext = GzipCompressor.Ext
+	if r.compressor != nil {
+		b.ext = r.compressor.Ext
+	}
+	b.path = s.Path + b.ext
+	rc, err = openBackup(r.fs, r.compressor, b)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return rc, err
+}
+
+// Close 关闭正在读取的文件
+func (r *segmentReader) Close() error {
+	var err error
+	if r.cur != nil {
+		err = r.cur.Close()
+		r.cur = nil
+	}
+	if r.live != nil {
+		if lerr := r.live.Close(); err == nil {
+			err = lerr
+		}
+		r.live = nil
+	}
+	r.segments = nil
+	return err
+}
+
+// limitedFile 只读取文件的前若干字节，关闭时关闭文件
+type limitedFile struct {
+	io.Reader
+	file File
+}
+
+func (f *limitedFile) Close() error {
+	return f.file.Close()
+}
+
+// lineFilter 逐行筛选时间在范围内的日志
+type lineFilter struct {
+	src  io.Closer
+	r    *bufio.Reader
+	opts ReadOptions
+	keep bool
+	buf  bytes.Buffer
+	err  error
+}
+
+func (f *lineFilter) Read(p []byte) (int, error) {
+	for f.buf.Len() == 0 && f.err == nil {
+		line, err := f.r.ReadBytes('\n')
+		if len(line) > 0 {
+			if t, ok := f.opts.Timestamp(line); ok {
+				f.keep = f.opts.contains(t)
+			}
+			if f.keep {
+				f.buf.Write(line)
+			}
+		}
+		f.err = err
+	}
+	if f.buf.Len() > 0 {
+		return f.buf.Read(p)
+	}
+	return 0, f.err
+}
+
+func (f *lineFilter) Close() error {
+	return f.src.Close()
+}
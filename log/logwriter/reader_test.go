@@ -0,0 +1,99 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc3339Timestamp 解析以 RFC3339 时间开头的日志行
+func rfc3339Timestamp(line []byte) (time.Time, bool) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, string(line[:i]))
+	return t, err == nil
+}
+
+func readAll(t *testing.T, fw *FileWriter, opts ReadOptions) string {
+	t.Helper()
+	r, err := fw.NewReader(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReader(t *testing.T) {
+	// 时钟使用与本地时区不同的时区，备份文件名中的时间按时钟的时区解析
+	start := time.Date(2019, 6, 1, 10, 0, 0, 0, time.FixedZone("UTC-11", -11*3600))
+	writer, _ := NewTimeSplitWriter(time.Hour)
+	_, clock := newMemWriter(writer, start)
+	writer.SetCompressor(GzipCompressor)
+	writer.SetBackupTemplate("{time:2006-01-02T15}.{seq}.log")
+	defer writer.Close()
+
+	var lines []string
+	for i := 0; i < 8; i++ {
+		line := clock.Now().Format(time.RFC3339) + " line\n"
+		mustWrite(t, writer, line)
+		lines = append(lines, line)
+		clock.Add(30 * time.Minute)
+	}
+	writer.compressor.wait()
+
+	segments, err := writer.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 4 {
+		t.Fatalf("got %d segments, want 4", len(segments))
+	}
+	for i, s := range segments {
+		if s.Live != (i == 3) || s.Compressed != (i < 3) {
+			t.Errorf("segment %d: got %+v", i, s)
+		}
+		if want := start.Add(time.Duration(i) * time.Hour); !s.Start.Equal(want) {
+			t.Errorf("segment %d: got start %v, want %v", i, s.Start, want)
+		}
+	}
+
+	// 打开后再切分，读到的仍是打开时的内容
+	r, err := writer.NewReader(ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, writer, clock.Now().Format(time.RFC3339)+" late\n")
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(lines, ""); string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+
+	// 只按文件的时间范围筛选
+	got := readAll(t, writer, ReadOptions{To: start.Add(2 * time.Hour)})
+	if want := strings.Join(lines[:4], ""); got != want {
+		t.Errorf("To: got %q, want %q", got, want)
+	}
+
+	// 逐行筛选
+	got = readAll(t, writer, ReadOptions{
+		From:      start.Add(90 * time.Minute),
+		To:        start.Add(3 * time.Hour),
+		Timestamp: rfc3339Timestamp,
+	})
+	if want := strings.Join(lines[3:6], ""); got != want {
+		t.Errorf("From/To: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package log
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+const defMaxRecordSize = 1 << 20
+
+// RecordMode 日志记录的边界，开启后只在记录边界处切分，一条记录总是完整地写入同一个文件
+type RecordMode int
+
+const (
+	// RecordNone 不区分记录，按字节切分
+	RecordNone RecordMode = iota
+	// RecordLine 以换行符作为记录边界
+	RecordLine
+	// RecordExplicit 只以 EndRecord、WriteRecord 作为记录边界，记录中可以包含换行符
+	RecordExplicit
+)
+
+// recordBuffer 缓存尚未结束的记录
+type recordBuffer struct {
+	mutex     sync.Mutex
+	mode      RecordMode
+	maxSize   int
+	partial   []byte
+	oversized int64
+}
+
+// SetRecordMode 开启按记录写入，Write 写入的数据先缓存到记录结束，
+// 切分只发生在记录之间，按大小切分时放不下下一条记录即提前切分。
+// 未结束的记录超过 maxRecordSize 字节(<=0 时为 1MB)时不再等待，作为一条记录直接写入。
+// 多个协程同时写入时每次 Write 应包含完整的记录，否则记录之间可能交错。
+// 需要在首次写入前调用
+func (fw *FileWriter) SetRecordMode(mode RecordMode, maxRecordSize int) {
+	if mode == RecordNone {
+		fw.records = nil
+		return
+	}
+	if maxRecordSize <= 0 {
+		maxRecordSize = defMaxRecordSize
+	}
+	fw.records = &recordBuffer{mode: mode, maxSize: maxRecordSize}
+}
+
+// OversizedRecords 返回因超过 maxRecordSize 而未等到结束就写入的记录数
+func (fw *FileWriter) OversizedRecords() int64 {
+	r := fw.records
+	if r == nil {
+		return 0
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.oversized
+}
+
+// WriteRecord 将已缓存的数据和 p 作为一条完整的记录写入，未开启按记录写入时等同于 Write
+func (fw *FileWriter) WriteRecord(p []byte) (int, error) {
+	r := fw.records
+	if r == nil {
+		return fw.Write(p)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	record := append(r.partial, p...)
+	r.partial = nil
+	if len(record) == 0 {
+		return 0, nil
+	}
+	written, err := fw.commitRecord(record)
+	return clampWritten(written, len(record)-len(p), len(p)), err
+}
+
+// EndRecord 将已缓存的数据作为一条完整的记录写入
+func (fw *FileWriter) EndRecord() error {
+	if fw.records == nil {
+		return nil
+	}
+	_, err := fw.WriteRecord(nil)
+	return err
+}
+
+// writeRecords 缓存 p 并写入其中已结束的记录，返回 p 中被接受的字节数
+func (fw *FileWriter) writeRecords(p []byte) (int, error) {
+	r := fw.records
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	buffered := len(r.partial)
+	data := append(r.partial, p...)
+	r.partial = nil
+	written := 0
+	for len(data) > 0 {
+		end := 0
+		if r.mode == RecordLine {
+			end = bytes.IndexByte(data, '\n') + 1
+		}
+		if end == 0 {
+			if len(data) <= r.maxSize {
+				r.partial = data
+				return len(p), nil
+			}
+			end = r.maxSize
+			r.oversized++
+		}
+		n, err := fw.commitRecord(data[:end])
+		written += n
+		if err != nil {
+			return clampWritten(written, buffered, len(p)), err
+		}
+		data = data[end:]
+	}
+	return len(p), nil
+}
+
+// clampWritten 将包含缓存数据在内的写入字节数换算为本次写入的字节数
+func clampWritten(written, buffered, n int) int {
+	written -= buffered
+	if written < 0 {
+		return 0
+	}
+	if written > n {
+		return n
+	}
+	return written
+}
+
+// commitRecord 写入一条完整的记录，开启按记录写入时调用方需持有 recordBuffer.mutex
+func (fw *FileWriter) commitRecord(record []byte) (int, error) {
+	if fw.async != nil {
+		return fw.async.put(record)
+	}
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.closed {
+		return 0, ErrClosed
+	}
+	n, err := fw.writeRecord(record)
+	if err != nil {
+		return fw.writeFailed(record, n, err)
+	}
+	return n, nil
+}
+
+// writeRecord 将一条记录完整写入当前日志文件，写入后超过大小上限时先切分，调用方需持有 fileMutex
+func (fw *FileWriter) writeRecord(record []byte) (written int, err error) {
+	if ok, err := fw.admit(record); !ok {
+		if err != nil {
+			return 0, err
+		}
+		return len(record), nil
+	}
+	defer fw.observeWrite(time.Now(), &written)
+	release, err := fw.setLock(lockShared)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	if fw.file == nil {
+		if err := fw.newFile(); err != nil {
+			return 0, err
+		}
+	}
+	if err := fw.checkRotate(); err != nil {
+		return 0, err
+	}
+	data, sum := record, []byte(nil)
+	if fw.chain != nil {
+		data, sum = fw.chain.frame(record)
+	}
+	if limit := fw.sizeLimit(); limit > 0 && fw.size > 0 && fw.size+int64(len(data)) > limit {
+		st := FileState{CreateTime: fw.createTime, Size: fw.size + int64(len(data))}
+		if trigger, ok := fw.splitPolicy().ShouldSplit(st, fw.now()); ok {
+			if err := fw.tryRotate(trigger); err != nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := fw.file.Write(data)
+	fw.size += int64(n)
+	if n == len(data) {
+		fw.track(data, 1)
+		if fw.chain != nil {
+			fw.chain.last = sum
+		}
+	} else {
+		fw.track(data[:n], 0)
+	}
+	if err != nil {
+		if fw.chain != nil {
+			// 带标记行的记录没有完整写入时整条记录按未写入处理
+			n = 0
+		}
+		return n, err
+	}
+	if err := fw.afterWrite(n); err != nil {
+		return len(record), err
+	}
+	return len(record), fw.checkRotate()
+}
+
+// flushRecord 将未结束的记录写入，Close 时调用，写入错误已通过错误回调报告
+func (fw *FileWriter) flushRecord() {
+	if fw.records != nil {
+		fw.EndRecord()
+	}
+}
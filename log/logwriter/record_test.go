@@ -0,0 +1,61 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordLineSplit(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(10)
+	writer.SetRecordMode(RecordLine, 8)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "aaa")
+	mustWrite(t, writer, "a\nbbb")
+	checkFiles(t, fs, defDir, map[string]string{defName: "aaaa\n"})
+	// 放不下下一条记录时提前切分
+	mustWrite(t, writer, "bbb\nc\n")
+	mustWrite(t, writer, "dd\n")
+	// 超过最大长度的记录不再等待结束
+	mustWrite(t, writer, "0123456789")
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "aaaa\n",
+		"2019-06-01.001.log": "bbbbbb\nc\n",
+		"2019-06-01.002.log": "dd\n",
+		defName:              "01234567",
+	})
+	if n := writer.OversizedRecords(); n != 1 {
+		t.Errorf("OversizedRecords: got %d, want 1", n)
+	}
+
+	writer.Close()
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "aaaa\n",
+		"2019-06-01.001.log": "bbbbbb\nc\n",
+		"2019-06-01.002.log": "dd\n",
+		"2019-06-01.003.log": "0123456789",
+		defName:              "",
+	})
+}
+
+func TestRecordExplicit(t *testing.T) {
+	writer, _ := NewSizeSplitWriter(10)
+	writer.SetRecordMode(RecordExplicit, 0)
+	writer.SetAsync(1024, OverflowBlock)
+	fs, _ := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+
+	mustWrite(t, writer, "panic:\n")
+	mustWrite(t, writer, "  at main\n")
+	if err := writer.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := writer.WriteRecord([]byte("ok\n")); err != nil || n != 3 {
+		t.Fatalf("WriteRecord: got (%d, %v)", n, err)
+	}
+	writer.Flush()
+	checkFiles(t, fs, defDir, map[string]string{
+		"2019-06-01.000.log": "panic:\n  at main\n",
+		defName:              "ok\n",
+	})
+	writer.Close()
+}
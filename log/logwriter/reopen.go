@@ -0,0 +1,119 @@
+package log
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defReopenCheckInterval 写入时检查日志文件是否被移走的最小间隔
+const defReopenCheckInterval = time.Second
+
+// NewReopenWriter 返回不自行切分的日志文件 writer，用于配合外部的 logrotate：
+// 调用 Reopen、收到 ReopenOnSignal 设置的信号，或写入时发现日志文件已被移走、删除(inode 变化)后，
+// 关闭并重新打开同一路径的日志文件。logrotate 使用 copytruncate 时无需重新打开
+func NewReopenWriter() (*FileWriter, error) {
+	w := &FileWriter{
+		dir:           defDir,
+		name:          defName,
+		splitType:     STypeReopen,
+		checkInterval: defCheckInterval,
+	}
+	return w, nil
+}
+
+// Reopen 关闭并重新打开同一路径的日志文件，文件不存在时创建，期间的写入等待重新打开后继续
+func (fw *FileWriter) Reopen() error {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if fw.closed {
+		return ErrClosed
+	}
+	if err := fw.reopen(); err != nil {
+		fw.reportError(err)
+		return err
+	}
+	return nil
+}
+
+// ReopenOnSignal 收到 sigs 中的信号时调用 Reopen，未指定时为 SIGHUP，Stop、Close 后停止监听
+func (fw *FileWriter) ReopenOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	fw.lifeMutex.Lock()
+	defer fw.lifeMutex.Unlock()
+	if fw.stopped {
+		return
+	}
+	if fw.stopCh == nil {
+		fw.stopCh = make(chan struct{})
+	}
+	stopCh := fw.stopCh
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	fw.checkWG.Add(1)
+	go func() {
+		defer fw.checkWG.Done()
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				fw.Reopen()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// reopen 关闭当前文件并重新打开，调用方需持有 fileMutex
+func (fw *FileWriter) reopen() error {
+	if fw.file != nil {
+		if fw.syncPolicy.Mode != SyncNever {
+			if err := fw.syncFile(); err != nil {
+				fw.reportError(err)
+			}
+		}
+		if err := fw.file.Close(); err != nil {
+			fw.reportError(err)
+		}
+		fw.file = nil
+	}
+	return fw.newFile()
+}
+
+// checkReopen 日志文件已被移走或删除时重新打开，被截断时更新文件大小，调用方需持有 fileMutex
+func (fw *FileWriter) checkReopen() error {
+	now := fw.now()
+	if now.Before(fw.reopenChecked.Add(defReopenCheckInterval)) {
+		return nil
+	}
+	fw.reopenChecked = now
+	current, err := fw.file.Stat()
+	if err != nil {
+		return err
+	}
+	info, err := fw.filesystem().Stat(fw.fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && sameFile(current, info) {
+		if info.Size() < fw.size {
+			fw.size = info.Size()
+		}
+		return nil
+	}
+	return fw.reopen()
+}
+
+// sameFile 判断两个 FileInfo 是否是同一个文件，支持 MemFS
+func sameFile(a, b os.FileInfo) bool {
+	if ea, ok := a.Sys().(*memEntry); ok {
+		eb, ok := b.Sys().(*memEntry)
+		return ok && ea == eb
+	}
+	return os.SameFile(a, b)
+}
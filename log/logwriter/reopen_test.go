@@ -0,0 +1,77 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReopenOnMove(t *testing.T) {
+	writer, _ := NewReopenWriter()
+	fs, clock := newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	live := filepath.Join(defDir, defName)
+
+	mustWrite(t, writer, "a\n")
+	// logrotate 移走文件后、下次检查前的写入仍然写入被移走的文件
+	fs.Rename(live, live+".1")
+	mustWrite(t, writer, "b\n")
+	clock.Add(time.Second)
+	mustWrite(t, writer, "c\n")
+	checkFiles(t, fs, defDir, map[string]string{defName + ".1": "a\nb\n", defName: "c\n"})
+
+	// copytruncate 截断文件后继续追加
+	fs.WriteFile(live, nil, 0644)
+	clock.Add(time.Second)
+	mustWrite(t, writer, "d\n")
+	checkFiles(t, fs, defDir, map[string]string{defName + ".1": "a\nb\n", defName: "d\n"})
+
+	fs.Remove(live)
+	if err := writer.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, writer, "e\n")
+	checkFiles(t, fs, defDir, map[string]string{defName + ".1": "a\nb\n", defName: "e\n"})
+	if len(writer.Rotations()) != 0 {
+		t.Errorf("got rotations %v, want none", writer.Rotations())
+	}
+	writer.Close()
+}
+
+func TestReopenOnSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, _ := NewReopenWriter()
+	writer.SetDir(dir)
+	writer.ReopenOnSignal()
+	defer writer.Close()
+	live := filepath.Join(dir, defName)
+	mustWrite(t, writer, "a\n")
+	if err := os.Rename(live, live+".1"); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skip(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(live); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file was not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mustWrite(t, writer, "b\n")
+	if data, _ := ioutil.ReadFile(live); string(data) != "b\n" {
+		t.Errorf("got %q, want %q", data, "b\n")
+	}
+}
@@ -0,0 +1,156 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy 备份文件保留策略，各字段为零值时表示不做对应限制
+type RetentionPolicy struct {
+	// MaxBackups 最多保留的备份文件个数
+	MaxBackups int
+	// MaxAge 备份文件最长保留时间，按文件最后修改时间计算
+	MaxAge time.Duration
+	// MaxSize 日志目录中当前文件与备份文件的总大小上限 单位 B
+	MaxSize int64
+}
+
+func (p RetentionPolicy) isZero() bool {
+	return p.MaxBackups <= 0 && p.MaxAge <= 0 && p.MaxSize <= 0
+}
+
+// backupFile 日志目录中的一个备份文件
+type backupFile struct {
+	path    string
+	key     string
+	stamp   time.Time
+	seq     int
+	ext     string
+	size    int64
+	modTime time.Time
+}
+
+// SetRetention 设置备份文件保留策略，每次切分后执行清理，开启压缩时在备份压缩完成后执行，不删除等待压缩或归档的备份
+func (fw *FileWriter) SetRetention(p RetentionPolicy) {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	fw.retention = p
+}
+
+// listBackups 按从旧到新的顺序列出日志目录中符合备份命名模板的文件，调用方需持有 fileMutex
+func (fw *FileWriter) listBackups() ([]backupFile, error) {
+	return listBackups(fw.filesystem(), fw.dir, fw.name, fw.template(), fw.location())
+}
+
+// listBackups 按从旧到新的顺序列出 dir 中符合日志 name 的备份命名模板 t 的文件，文件名中的时间按 loc 解析
+func listBackups(fs FileSystem, dir, name string, t *BackupTemplate, loc *time.Location) ([]backupFile, error) {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := t.matcher(name, loc)
+	var backups []backupFile
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		key, stamp, seq, ext, ok := m.match(info.Name())
+		if !ok {
+			continue
+		}
+		backups = append(backups, backupFile{
+			path:    filepath.Join(dir, info.Name()),
+			key:     key,
+			stamp:   stamp,
+			seq:     seq,
+			ext:     ext,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	// 压缩完成到删除源文件之间源文件和压缩文件同时存在，只保留压缩文件
+	compressed := make(map[string]bool)
+	for _, b := range backups {
+		if b.ext != "" {
+			compressed[strings.TrimSuffix(b.path, b.ext)] = true
+		}
+	}
+	merged := backups[:0]
+	for _, b := range backups {
+		if b.ext != "" || !compressed[b.path] {
+			merged = append(merged, b)
+		}
+	}
+	backups = merged
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].stamp.Equal(backups[j].stamp) {
+			return backups[i].stamp.Before(backups[j].stamp)
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	return backups, nil
+}
+
+// prune 按照保留策略删除多余的备份文件，只处理符合备份命名模板的文件
+func (fw *FileWriter) prune() error {
+	p := fw.retention
+	if p.isZero() {
+		return nil
+	}
+	backups, err := fw.listBackups()
+	if err != nil {
+		return err
+	}
+
+	remove := make([]bool, len(backups))
+	if p.MaxBackups > 0 && len(backups) > p.MaxBackups {
+		for i := 0; i < len(backups)-p.MaxBackups; i++ {
+			remove[i] = true
+		}
+	}
+	if p.MaxAge > 0 {
+		cutoff := fw.now().Add(-p.MaxAge)
+		for i, b := range backups {
+			if b.modTime.Before(cutoff) {
+				remove[i] = true
+			}
+		}
+	}
+	if p.MaxSize > 0 {
+		var total int64
+		if info, err := fw.filesystem().Stat(fw.fileName); err == nil {
+			total += info.Size()
+		}
+		for i, b := range backups {
+			if !remove[i] {
+				total += b.size
+			}
+		}
+		for i, b := range backups {
+			if total <= p.MaxSize {
+				break
+			}
+			if !remove[i] {
+				remove[i] = true
+				total -= b.size
+			}
+		}
+	}
+
+	var firstErr error
+	for i, b := range backups {
+		if !remove[i] || fw.compressor != nil && fw.compressor.queued(b.path) {
+			continue
+		}
+		if fw.archiver != nil && fw.archiver.queued(b.path) {
+			continue
+		}
+		if err := fw.filesystem().Remove(b.path); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,114 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, size int, mod time.Time) {
+	if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listNames(t *testing.T, dir string) map[string]bool {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	return names
+}
+
+func TestRetentionPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	writeTestFile(t, filepath.Join(dir, "2019-01-01.000.log"), 100, now.Add(-72*time.Hour))
+	writeTestFile(t, filepath.Join(dir, "2019-01-02.000.log"), 100, now.Add(-48*time.Hour))
+	writeTestFile(t, filepath.Join(dir, "2019-01-02.001.log"), 100, now.Add(-47*time.Hour))
+	writeTestFile(t, filepath.Join(dir, "2019-01-03.000.log"), 100, now.Add(-time.Hour))
+	writeTestFile(t, filepath.Join(dir, "2019-01-03.001.log"), 100, now)
+	writeTestFile(t, filepath.Join(dir, "other.log"), 100, now.Add(-96*time.Hour))
+	writeTestFile(t, filepath.Join(dir, defName), 50, now)
+
+	fw := &FileWriter{dir: dir, name: defName, fileName: filepath.Join(dir, defName)}
+
+	fw.SetRetention(RetentionPolicy{MaxBackups: 4})
+	if err := fw.prune(); err != nil {
+		t.Fatal(err)
+	}
+	names := listNames(t, dir)
+	if names["2019-01-01.000.log"] || !names["2019-01-02.000.log"] {
+		t.Errorf("MaxBackups: unexpected files %v", names)
+	}
+
+	fw.SetRetention(RetentionPolicy{MaxAge: 24 * time.Hour})
+	if err := fw.prune(); err != nil {
+		t.Fatal(err)
+	}
+	names = listNames(t, dir)
+	if names["2019-01-02.000.log"] || names["2019-01-02.001.log"] || !names["2019-01-03.000.log"] {
+		t.Errorf("MaxAge: unexpected files %v", names)
+	}
+
+	fw.SetRetention(RetentionPolicy{MaxSize: 160})
+	if err := fw.prune(); err != nil {
+		t.Fatal(err)
+	}
+	names = listNames(t, dir)
+	if names["2019-01-03.000.log"] || !names["2019-01-03.001.log"] {
+		t.Errorf("MaxSize: unexpected files %v", names)
+	}
+	if !names["other.log"] || !names[defName] {
+		t.Errorf("files outside backup naming scheme were removed: %v", names)
+	}
+}
+
+func TestRetentionSharedDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 使用默认命名模板的不同日志共用一个目录，只清理自己的备份
+	for _, name := range []string{
+		"2019-06-01.000.log", "2019-06-02.000.log",
+		"a.2019-06-01.000.log", "a.2019-06-02.000.log",
+		"b.2019-06-01.000.log", "b.2019-06-02.000.log",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{defName, "a.log"} {
+		fw := &FileWriter{dir: dir, name: name, fileName: filepath.Join(dir, name)}
+		fw.SetRetention(RetentionPolicy{MaxBackups: 1})
+		if err := fw.prune(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := listNames(t, dir)
+	for _, name := range []string{"2019-06-02.000.log", "a.2019-06-02.000.log", "b.2019-06-01.000.log", "b.2019-06-02.000.log"} {
+		if !names[name] {
+			t.Errorf("%s was removed", name)
+		}
+	}
+	if len(names) != 4 {
+		t.Errorf("got files %v", names)
+	}
+}
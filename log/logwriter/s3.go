@@ -0,0 +1,175 @@
+package log
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefRegion      = "us-east-1"
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3TimeFormat     = "20060102T150405Z"
+	s3DateFormat     = "20060102"
+	s3ErrorBodyLimit = 1024
+)
+
+// S3Sink 将备份文件上传到兼容 S3 协议的对象存储(如 MinIO)，使用 path-style 地址和 AWS 签名 V4
+type S3Sink struct {
+	// Endpoint 服务地址，如 http://127.0.0.1:9000
+	Endpoint string
+	// Region 区域，默认 us-east-1
+	Region string
+	// Bucket 存储桶
+	Bucket string
+	// Prefix 对象名前缀，如 "logs/node1/"
+	Prefix string
+	// AccessKey 访问密钥 ID
+	AccessKey string
+	// SecretKey 访问密钥
+	SecretKey string
+	// Client 发送请求使用的 http.Client，为 nil 时使用 http.DefaultClient
+	Client *http.Client
+}
+
+// Archive 实现 ArchiveSink 接口，以 PUT 请求上传对象
+func (s *S3Sink) Archive(ctx context.Context, name string, r io.Reader, size int64) error {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return err
+	}
+	u.Path = "/" + s.Bucket + "/" + s.Prefix + name
+	u.RawPath = s3EscapePath(u.Path)
+	req, err := http.NewRequest(http.MethodPut, u.String(), ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, s3ErrorBodyLimit))
+		return fmt.Errorf("logwriter: s3 put %s: %s: %s", u.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+func (s *S3Sink) region() string {
+	if s.Region == "" {
+		return s3DefRegion
+	}
+	return s.Region
+}
+
+// sign 按 AWS 签名 V4 为请求添加 Authorization 头，请求中已有的头部全部参与签名，
+// 没有设置 X-Amz-Content-Sha256 时请求体不参与签名
+func (s *S3Sink) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format(s3TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", s3UnsignedBody)
+	}
+
+	scope := now.Format(s3DateFormat) + "/" + s.region() + "/s3/aws4_request"
+	signedHeaders, canonical := s3CanonicalRequest(req)
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonical)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3CanonicalRequest 返回参与签名的头部列表和规范请求
+func s3CanonicalRequest(req *http.Request) (string, string) {
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower != "authorization" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	return signedHeaders, canonical
+}
+
+// s3EscapePath 按 S3 规则编码路径，除非保留字符和 "/" 外全部百分号编码
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
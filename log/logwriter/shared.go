@@ -0,0 +1,303 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const lockSuffix = ".lock"
+
+// FileLock 进程间的咨询锁，同一个 FileLock 同时只持有一种锁，加锁时先释放已持有的锁
+type FileLock interface {
+	// Lock 阻塞直到获得排他锁
+	Lock() error
+	// RLock 阻塞直到获得共享锁
+	RLock() error
+	// Unlock 释放已持有的锁
+	Unlock() error
+	// Close 释放锁并关闭锁文件
+	Close() error
+}
+
+// LockFS 由支持进程间咨询锁的文件系统实现，OSFileSystem 在 linux 和 darwin 上通过 flock 实现
+type LockFS interface {
+	// OpenLock 打开(不存在时创建)锁文件
+	OpenLock(name string, perm os.FileMode) (FileLock, error)
+}
+
+// ErrSharedConflict 多进程共享与防篡改模式、文件头或文件尾同时开启时返回
+var ErrSharedConflict = errors.New("logwriter: shared rotation cannot be used with integrity, header or footer")
+
+// errNoLockFS 开启多进程共享但文件系统不支持咨询锁时返回
+var errNoLockFS = errors.New("logwriter: file system does not support file locks")
+
+// OpenLock 见 LockFS 接口
+func (OSFileSystem) OpenLock(name string, perm os.FileMode) (FileLock, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &osFileLock{file: f}, nil
+}
+
+// osFileLock 通过 flock 实现的咨询锁
+type osFileLock struct {
+	file *os.File
+}
+
+func (l *osFileLock) Lock() error {
+	return flock(l.file, lockExclusive)
+}
+
+func (l *osFileLock) RLock() error {
+	return flock(l.file, lockShared)
+}
+
+func (l *osFileLock) Unlock() error {
+	return flock(l.file, lockNone)
+}
+
+func (l *osFileLock) Close() error {
+	return l.file.Close()
+}
+
+// lockMode FileWriter 持有的进程间锁
+type lockMode int
+
+const (
+	lockNone lockMode = iota
+	lockShared
+	lockExclusive
+)
+
+// SetSharedRotation 开启多进程共享日志文件，多个进程的 FileWriter 使用相同的日志目录和文件名时，
+// 通过日志目录中的锁文件 .<name>.lock 上的咨询锁协调切分：
+// 每次写入持有共享锁，切分和创建日志文件持有排他锁，同一次切分只由一个进程执行，
+// 其余进程在下次写入时发现日志文件已被切分，重新打开新的日志文件。
+// 备份的压缩、归档和清理由执行切分的进程完成。
+// 开启后每次写入多两次 stat 和两次加解锁，按大小切分时各进程的写入可能使文件略超过上限；
+// 文件头、文件尾和防篡改模式只反映本进程写入的内容，已开启其中之一时返回 ErrSharedConflict。
+// 文件系统需要实现 LockFS，需要在首次写入前调用
+func (fw *FileWriter) SetSharedRotation(enabled bool) error {
+	fw.fileMutex.Lock()
+	defer fw.fileMutex.Unlock()
+	if enabled && fw.perProcessContent() {
+		return ErrSharedConflict
+	}
+	fw.shared = enabled
+	if !enabled {
+		fw.closeLock()
+	}
+	return nil
+}
+
+// perProcessContent 判断是否开启了只反映本进程写入内容的防篡改模式、文件头或文件尾，调用方需持有 fileMutex
+func (fw *FileWriter) perProcessContent() bool {
+	return fw.chain != nil || fw.header != nil || fw.footer != nil
+}
+
+// lockName 返回锁文件路径
+func (fw *FileWriter) lockName() string {
+	return filepath.Join(fw.dir, "."+fw.name+lockSuffix)
+}
+
+// setLock 将持有的进程间锁改为 mode，返回恢复原来锁的函数，未开启多进程共享时什么都不做。
+// flock 改变锁的类型不是原子的，改为排他锁后需要重新检查日志文件是否已被其他进程切分，调用方需持有 fileMutex
+func (fw *FileWriter) setLock(mode lockMode) (func(), error) {
+	if !fw.shared || fw.lockMode == mode || (mode == lockShared && fw.lockMode == lockExclusive) {
+		return func() {}, nil
+	}
+	if fw.lock == nil || fw.lockPath != fw.lockName() {
+		if err := fw.openLock(); err != nil {
+			return nil, err
+		}
+	}
+	prev := fw.lockMode
+	if err := fw.switchLock(mode); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := fw.switchLock(prev); err != nil {
+			fw.reportError(err)
+		}
+	}, nil
+}
+
+// switchLock 改为持有 mode 类型的锁，调用方需持有 fileMutex
+func (fw *FileWriter) switchLock(mode lockMode) error {
+	var err error
+	switch mode {
+	case lockShared:
+		err = fw.lock.RLock()
+	case lockExclusive:
+		err = fw.lock.Lock()
+	default:
+		err = fw.lock.Unlock()
+	}
+	if err != nil {
+		fw.lockMode = lockNone
+		return err
+	}
+	fw.lockMode = mode
+	return nil
+}
+
+// openLock 打开锁文件，日志目录不存在时创建，调用方需持有 fileMutex
+func (fw *FileWriter) openLock() error {
+	fw.closeLock()
+	lfs, ok := fw.filesystem().(LockFS)
+	if !ok {
+		return errNoLockFS
+	}
+	name := fw.lockName()
+	l, err := lfs.OpenLock(name, fw.filePerm())
+	if os.IsNotExist(err) {
+		if err := fw.filesystem().MkdirAll(fw.dir, fw.dirPerm()); err != nil {
+			return err
+		}
+		if err := fw.applyPerm(fw.dir, fw.perm.DirMode); err != nil {
+			fw.reportError(err)
+		}
+		l, err = lfs.OpenLock(name, fw.filePerm())
+	}
+	if err != nil {
+		return err
+	}
+	fw.lock = l
+	fw.lockPath = name
+	fw.lockMode = lockNone
+	return nil
+}
+
+// closeLock 释放并关闭锁文件，调用方需持有 fileMutex
+func (fw *FileWriter) closeLock() {
+	if fw.lock == nil {
+		return
+	}
+	if err := fw.lock.Close(); err != nil {
+		fw.reportError(err)
+	}
+	fw.lock = nil
+	fw.lockPath = ""
+	fw.lockMode = lockNone
+}
+
+// checkShared 日志文件已被其他进程切分时重新打开，并按文件的实际大小更新 size，调用方需持有 fileMutex
+func (fw *FileWriter) checkShared() error {
+	moved, err := fw.rotatedElsewhere()
+	if err != nil {
+		return err
+	}
+	if moved {
+		return fw.reopen()
+	}
+	info, err := fw.file.Stat()
+	if err != nil {
+		return err
+	}
+	fw.size = info.Size()
+	return nil
+}
+
+// rotatedElsewhere 判断打开的日志文件是否已不是日志路径上的文件，调用方需持有 fileMutex
+func (fw *FileWriter) rotatedElsewhere() (bool, error) {
+	current, err := fw.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	info, err := fw.filesystem().Stat(fw.fileName)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !sameFile(current, info), nil
+}
+
+// OpenLock 见 LockFS 接口，同一个 MemFS 上打开的锁之间按 flock 的语义互斥，用于模拟多个进程
+func (m *MemFS) OpenLock(name string, perm os.FileMode) (FileLock, error) {
+	f, err := m.OpenFile(name, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = m.resolve(name)
+	if m.locks == nil {
+		m.locks = make(map[string]*memLockState)
+	}
+	st, ok := m.locks[name]
+	if !ok {
+		st = &memLockState{}
+		st.cond = sync.NewCond(&st.mutex)
+		m.locks[name] = st
+	}
+	return &memLock{state: st}, nil
+}
+
+// memLockState 一个锁文件上的加锁状态
+type memLockState struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	readers int
+	writer  bool
+}
+
+// memLock MemFS 上打开的一个锁
+type memLock struct {
+	state *memLockState
+	mode  lockMode
+}
+
+func (l *memLock) Lock() error {
+	s := l.state
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l.release()
+	for s.writer || s.readers > 0 {
+		s.cond.Wait()
+	}
+	s.writer = true
+	l.mode = lockExclusive
+	return nil
+}
+
+func (l *memLock) RLock() error {
+	s := l.state
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l.release()
+	for s.writer {
+		s.cond.Wait()
+	}
+	s.readers++
+	l.mode = lockShared
+	return nil
+}
+
+func (l *memLock) Unlock() error {
+	l.state.mutex.Lock()
+	defer l.state.mutex.Unlock()
+	l.release()
+	return nil
+}
+
+func (l *memLock) Close() error {
+	return l.Unlock()
+}
+
+// release 释放持有的锁，调用方需持有 state.mutex
+func (l *memLock) release() {
+	switch l.mode {
+	case lockShared:
+		l.state.readers--
+	case lockExclusive:
+		l.state.writer = false
+	}
+	l.mode = lockNone
+	l.state.cond.Broadcast()
+}
@@ -0,0 +1,172 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSharedWriters 返回共享同一个文件系统、模拟多个进程的 writer
+func newSharedWriters(n int, fs FileSystem, clock Clock, dir string, size int64) []*FileWriter {
+	writers := make([]*FileWriter, n)
+	for i := range writers {
+		if size > 0 {
+			writers[i], _ = NewSizeSplitWriter(size)
+		} else {
+			writers[i], _ = NewDateSplitWriter()
+		}
+		writers[i].SetDir(dir)
+		writers[i].SetFileSystem(fs)
+		writers[i].SetClock(clock)
+		writers[i].SetSharedRotation(true)
+	}
+	return writers
+}
+
+func TestSharedRotationOnce(t *testing.T) {
+	clock := newManualClock(time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+	fs := NewMemFS(clock)
+	writers := newSharedWriters(2, fs, clock, defDir, 0)
+
+	mustWrite(t, writers[0], "a\n")
+	mustWrite(t, writers[1], "b\n")
+	clock.Add(24 * time.Hour)
+	mustWrite(t, writers[0], "c\n")
+	mustWrite(t, writers[1], "d\n")
+	for _, w := range writers {
+		w.Close()
+	}
+	checkFiles(t, fs, defDir, map[string]string{"2019-06-01.000.log": "a\nb\n", defName: "c\nd\n"})
+	if n0, n1 := len(writers[0].Rotations()), len(writers[1].Rotations()); n0 != 1 || n1 != 0 {
+		t.Errorf("got rotations %v and %v, want exactly one", writers[0].Rotations(), writers[1].Rotations())
+	}
+}
+
+// checkSharedLines 检查每个写入的行在日志目录中出现且只出现一次，返回备份文件数
+func checkSharedLines(t *testing.T, fs FileSystem, dir string, want int) int {
+	t.Helper()
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	backups := 0
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if info.Name() != defName {
+			backups++
+		}
+		data, err := readFile(fs, filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if line != "" {
+				seen[line]++
+			}
+		}
+	}
+	if len(seen) != want {
+		t.Errorf("got %d distinct lines, want %d", len(seen), want)
+	}
+	for line, n := range seen {
+		if n != 1 {
+			t.Errorf("line %q written %d times", line, n)
+		}
+	}
+	return backups
+}
+
+func testSharedConcurrent(t *testing.T, fs FileSystem, dir string) {
+	writers := newSharedWriters(3, fs, nil, dir, 120)
+	var wg sync.WaitGroup
+	for i, w := range writers {
+		w.SetRecordMode(RecordLine, 0)
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func(w *FileWriter, id int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					mustWrite(t, w, fmt.Sprintf("%d-%03d\n", id, j))
+				}
+			}(w, i*2+g)
+		}
+	}
+	wg.Wait()
+	var rotations int64
+	for _, w := range writers {
+		w.Close()
+		for _, n := range w.Rotations() {
+			rotations += n
+		}
+	}
+	backups := checkSharedLines(t, fs, dir, 600)
+	if int64(backups) != rotations {
+		t.Errorf("got %d backups for %d rotations", backups, rotations)
+	}
+	if backups < 20 {
+		t.Errorf("got %d backups, want at least 20", backups)
+	}
+}
+
+func TestSharedRotationMemFS(t *testing.T) {
+	testSharedConcurrent(t, NewMemFS(nil), defDir)
+}
+
+func TestSharedRotationFlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logwriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := OSFileSystem{}.OpenLock(filepath.Join(dir, "probe.lock"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Lock(); err != nil {
+		t.Skip(err)
+	}
+	l.Close()
+	testSharedConcurrent(t, OSFileSystem{}, filepath.Join(dir, "logs"))
+}
+
+func TestSharedRotationConflict(t *testing.T) {
+	// 已开启文件头、文件尾或防篡改模式时不能开启多进程共享
+	for name, set := range map[string]func(fw *FileWriter) error{
+		"header":    func(fw *FileWriter) error { return fw.SetHeader(DefaultHeader) },
+		"footer":    func(fw *FileWriter) error { return fw.SetFooter(DefaultFooter) },
+		"integrity": func(fw *FileWriter) error { return fw.SetIntegrity(IntegritySHA256, nil) },
+	} {
+		writer, _ := NewDateSplitWriter()
+		newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+		if err := set(writer); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := writer.SetSharedRotation(true); err != ErrSharedConflict {
+			t.Errorf("%s then SetSharedRotation: got %v, want %v", name, err, ErrSharedConflict)
+		}
+		if err := writer.Apply(&Config{SharedRotation: true}); err != ErrSharedConflict {
+			t.Errorf("%s then Apply: got %v, want %v", name, err, ErrSharedConflict)
+		}
+		writer.Close()
+
+		// 反之亦然
+		writer, _ = NewDateSplitWriter()
+		newMemWriter(writer, time.Date(2019, 6, 1, 10, 0, 0, 0, time.Local))
+		if err := writer.SetSharedRotation(true); err != nil {
+			t.Fatal(err)
+		}
+		if err := set(writer); err != ErrSharedConflict {
+			t.Errorf("SetSharedRotation then %s: got %v, want %v", name, err, ErrSharedConflict)
+		}
+		writer.Close()
+	}
+}